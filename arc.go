@@ -0,0 +1,160 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+// NewARC returns an EvictionPolicy implementing the Adaptive
+// Replacement Cache algorithm. It keeps entries used once recently
+// apart from entries used more than once, and remembers the keys it
+// evicted from each. Missing a remembered key moves the balance
+// towards the side it was evicted from, so that the policy adapts
+// between recency and frequency and a scan can't flush out the
+// frequently used entries.
+//
+// ARC's capacity is counted in entries; here it is the number of
+// entries held when Evict is called.
+func NewARC() EvictionPolicy {
+	return &arcPolicy{
+		t1: newLRUList(),
+		t2: newLRUList(),
+		b1: newLRUList(),
+		b2: newLRUList(),
+	}
+}
+
+type arcPolicy struct {
+	t1 lruList // entries used once recently
+	t2 lruList // entries used at least twice recently
+	b1 lruList // keys evicted from t1, without values
+	b2 lruList // keys evicted from t2, without values
+	p  int     // target length of t1
+}
+
+func (p *arcPolicy) Add(key string, value ByteView) (old ByteView, replaced bool) {
+	if e, ok := p.hit(key); ok {
+		old, e.value = e.value, value
+		return old, true
+	}
+	c := p.t1.len() + p.t2.len()
+	if e, ok := p.b1.get(key); ok {
+		// Evicted too early from t1: favor recency.
+		p.p = minInt(p.p+maxInt(p.b2.len()/p.b1.len(), 1), c)
+		p.b1.remove(e)
+		p.t2.pushFront(&policyEntry{key: key, value: value})
+		return
+	}
+	if e, ok := p.b2.get(key); ok {
+		// Evicted too early from t2: favor frequency.
+		p.p = maxInt(p.p-maxInt(p.b1.len()/p.b2.len(), 1), 0)
+		p.b2.remove(e)
+		p.t2.pushFront(&policyEntry{key: key, value: value})
+		return
+	}
+	p.t1.pushFront(&policyEntry{key: key, value: value})
+	return
+}
+
+func (p *arcPolicy) Get(key string) (value ByteView, ok bool) {
+	e, ok := p.hit(key)
+	if !ok {
+		return
+	}
+	return e.value, true
+}
+
+// hit records an access to key, moving it to the front of t2.
+func (p *arcPolicy) hit(key string) (*policyEntry, bool) {
+	if e, ok := p.t1.get(key); ok {
+		p.t1.remove(e)
+		p.t2.pushFront(e)
+		return e, true
+	}
+	if e, ok := p.t2.get(key); ok {
+		p.t2.moveToFront(e)
+		return e, true
+	}
+	return nil, false
+}
+
+func (p *arcPolicy) Remove(key string) (value ByteView, ok bool) {
+	if e, ok := p.t1.get(key); ok {
+		p.t1.remove(e)
+		return e.value, true
+	}
+	if e, ok := p.t2.get(key); ok {
+		p.t2.remove(e)
+		return e.value, true
+	}
+	return
+}
+
+func (p *arcPolicy) Evict() (key string, value ByteView, ok bool) {
+	var e *policyEntry
+	if p.t1.len() > 0 && (p.t1.len() > p.p || p.t2.len() == 0) {
+		e, _ = p.t1.oldest()
+		p.t1.remove(e)
+		p.b1.pushFront(&policyEntry{key: e.key})
+	} else if p.t2.len() > 0 {
+		e, _ = p.t2.oldest()
+		p.t2.remove(e)
+		p.b2.pushFront(&policyEntry{key: e.key})
+	} else {
+		return
+	}
+	p.trimGhosts()
+	return e.key, e.value, true
+}
+
+// trimGhosts bounds the remembered keys: t1 and b1 together, and all
+// four lists together, hold at most c and 2c keys, c being the
+// number of entries held.
+func (p *arcPolicy) trimGhosts() {
+	c := p.t1.len() + p.t2.len()
+	for p.t1.len()+p.b1.len() > c && p.b1.len() > 0 {
+		e, _ := p.b1.oldest()
+		p.b1.remove(e)
+	}
+	for c+p.b1.len()+p.b2.len() > 2*c {
+		ghosts := &p.b2
+		if ghosts.len() == 0 {
+			ghosts = &p.b1
+		}
+		e, _ := ghosts.oldest()
+		ghosts.remove(e)
+	}
+}
+
+func (p *arcPolicy) Len() int { return p.t1.len() + p.t2.len() }
+
+func (p *arcPolicy) Range(fn func(key string, value ByteView) bool) {
+	if p.t1.rangeOldest(fn) {
+		p.t2.rangeOldest(fn)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
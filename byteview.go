@@ -21,6 +21,7 @@ import (
 	"errors"
 	"io"
 	"strings"
+	"time"
 )
 
 // A ByteView holds an immutable view of bytes.
@@ -36,6 +37,23 @@ type ByteView struct {
 	// 如果b是非nil，则使用b，否则使用s
 	b []byte
 	s string
+
+	// e is the time at which the value expires. The zero
+	// Time means the value never expires.
+	// e 为过期时间，零值表示永不过期
+	e time.Time
+}
+
+// Expire returns the time at which the view's value expires,
+// or the zero Time if it never expires.
+// 返回 view 的过期时间，零值表示永不过期
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+// expired reports whether the view's value has expired at now.
+func (v ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && !now.Before(v.e)
 }
 
 // Len returns the view's length.
@@ -79,18 +97,18 @@ func (v ByteView) At(i int) byte {
 // 返回从索引from到to的view的切分结果
 func (v ByteView) Slice(from, to int) ByteView {
 	if v.b != nil {
-		return ByteView{b: v.b[from:to]}
+		return ByteView{b: v.b[from:to], e: v.e}
 	}
-	return ByteView{s: v.s[from:to]}
+	return ByteView{s: v.s[from:to], e: v.e}
 }
 
 // SliceFrom slices the view from the provided index until the end.
 // 相当于上面的to为len(b)
 func (v ByteView) SliceFrom(from int) ByteView {
 	if v.b != nil {
-		return ByteView{b: v.b[from:]}
+		return ByteView{b: v.b[from:], e: v.e}
 	}
-	return ByteView{s: v.s[from:]}
+	return ByteView{s: v.s[from:], e: v.e}
 }
 
 // Copy copies b into dest and returns the number of bytes copied.
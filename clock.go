@@ -0,0 +1,136 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"container/list"
+	"sync/atomic"
+)
+
+// NewCLOCK returns an EvictionPolicy implementing the CLOCK
+// algorithm, an approximation of LRU. Entries sit on a circular list
+// swept by a hand. A hit only sets the entry's reference bit, without
+// moving it, so the policy is a SharedGetter and hits need no write
+// lock. When evicting, the hand clears the bits it passes and evicts
+// the first entry whose bit was already clear.
+func NewCLOCK() EvictionPolicy {
+	return &clockPolicy{
+		ring:  list.New(),
+		items: make(map[string]*clockEntry),
+	}
+}
+
+type clockPolicy struct {
+	ring  *list.List // of *clockEntry
+	hand  *list.Element
+	items map[string]*clockEntry
+}
+
+type clockEntry struct {
+	key        string
+	value      ByteView
+	referenced int32 // accessed atomically
+	elem       *list.Element
+}
+
+func (p *clockPolicy) Add(key string, value ByteView) (old ByteView, replaced bool) {
+	if e, ok := p.items[key]; ok {
+		old, e.value = e.value, value
+		atomic.StoreInt32(&e.referenced, 1)
+		return old, true
+	}
+	e := &clockEntry{key: key, value: value}
+	// Insert just behind the hand, so that a new entry is the last
+	// one the hand reaches.
+	if p.hand == nil {
+		e.elem = p.ring.PushBack(e)
+	} else {
+		e.elem = p.ring.InsertBefore(e, p.hand)
+	}
+	p.items[key] = e
+	return
+}
+
+func (p *clockPolicy) Get(key string) (value ByteView, ok bool) {
+	return p.GetShared(key)
+}
+
+func (p *clockPolicy) GetShared(key string) (value ByteView, ok bool) {
+	e, ok := p.items[key]
+	if !ok {
+		return
+	}
+	if atomic.LoadInt32(&e.referenced) == 0 {
+		atomic.StoreInt32(&e.referenced, 1)
+	}
+	return e.value, true
+}
+
+func (p *clockPolicy) Remove(key string) (value ByteView, ok bool) {
+	e, ok := p.items[key]
+	if !ok {
+		return
+	}
+	p.unlink(e)
+	return e.value, true
+}
+
+func (p *clockPolicy) Evict() (key string, value ByteView, ok bool) {
+	if p.ring.Len() == 0 {
+		return
+	}
+	for {
+		if p.hand == nil {
+			p.hand = p.ring.Front()
+		}
+		e := p.hand.Value.(*clockEntry)
+		if atomic.LoadInt32(&e.referenced) == 0 {
+			p.unlink(e)
+			return e.key, e.value, true
+		}
+		atomic.StoreInt32(&e.referenced, 0)
+		p.hand = p.hand.Next()
+	}
+}
+
+// unlink removes e, moving the hand past it if needed.
+func (p *clockPolicy) unlink(e *clockEntry) {
+	if p.hand == e.elem {
+		p.hand = e.elem.Next()
+	}
+	p.ring.Remove(e.elem)
+	delete(p.items, e.key)
+}
+
+func (p *clockPolicy) Len() int { return len(p.items) }
+
+// Range goes around the ring from the hand, the way Evict does.
+func (p *clockPolicy) Range(fn func(key string, value ByteView) bool) {
+	start := p.hand
+	if start == nil {
+		start = p.ring.Front()
+	}
+	for i, e := 0, start; i < p.ring.Len(); i++ {
+		ce := e.Value.(*clockEntry)
+		if !fn(ce.key, ce.value) {
+			return
+		}
+		if e = e.Next(); e == nil {
+			e = p.ring.Front()
+		}
+	}
+}
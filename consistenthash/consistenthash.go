@@ -20,6 +20,7 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
@@ -29,13 +30,20 @@ type Hash func(data []byte) uint32
 
 type Map struct {
 	// 函数
-	hash     Hash
+	hash Hash
 	// 虚拟节点个数
 	replicas int
 	// 哈希环上的点列表
-	keys     []int // Sorted
-	// 哈希环上点到服务器名的映射
-	hashMap  map[int]string
+	points []point // Sorted by hash, then by item
+	// 每个服务器的虚拟节点个数
+	items map[string]int
+}
+
+// A point is one replica of an item on the ring.
+// 环上的一个虚拟节点
+type point struct {
+	hash int
+	item string
 }
 
 /*
@@ -46,7 +54,7 @@ func New(replicas int, fn Hash) *Map {
 	m := &Map{
 		replicas: replicas,
 		hash:     fn,
-		hashMap:  make(map[int]string),
+		items:    make(map[string]int),
 	}
 	// 默认哈希函数
 	if m.hash == nil {
@@ -58,50 +66,186 @@ func New(replicas int, fn Hash) *Map {
 // IsEmpty returns true if there are no items available.
 // 判断 Map 是否为空
 func (m *Map) IsEmpty() bool {
-	return len(m.keys) == 0
+	return len(m.points) == 0
 }
 
-// Add adds some keys to the hash.
+// Add adds some keys to the hash, each with the Map's number of
+// replicas. Adding a key that is already in the hash resets its
+// number of replicas.
 // 将缓存服务器加到Map中
 func (m *Map) Add(keys ...string) {
+	m.add(m.replicas, keys)
+}
+
+// AddWeighted adds some keys to the hash with weight times the Map's
+// number of replicas, rounded, so that they get a share of the keys in
+// proportion to weight. A key with a positive weight gets at least one
+// replica; a key with a zero or negative weight is removed.
+// 按权重添加服务器，虚拟节点个数为 replicas * weight
+func (m *Map) AddWeighted(weight float64, keys ...string) {
+	replicas := int(math.Round(float64(m.replicas) * weight))
+	if replicas < 1 && weight > 0 {
+		replicas = 1
+	}
+	m.add(replicas, keys)
+}
+
+// add adds keys with replicas points each.
+func (m *Map) add(replicas int, keys []string) {
+	var stale []string
 	for _, key := range keys {
+		if n, ok := m.items[key]; ok && n != replicas {
+			stale = append(stale, key)
+		}
+	}
+	m.Remove(stale...)
+
+	added := false
+	for _, key := range keys {
+		if _, ok := m.items[key]; ok || replicas <= 0 {
+			continue
+		}
+		m.items[key] = replicas
 		// 遍历虚拟节点
-		for i := 0; i < m.replicas; i++ {
+		for i := 0; i < replicas; i++ {
 			// key + 编号 算哈希值
 			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-			// 将点的哈希值 添加到 keys 内
-			m.keys = append(m.keys, hash)
 			// 将虚拟节点关联到服务器上
-			m.hashMap[hash] = key
+			m.points = append(m.points, point{hash, key})
 		}
+		added = true
+	}
+	if !added {
+		return
 	}
-	// 升序排列虚拟节点
-	sort.Ints(m.keys)
+	// Points of different keys may hash to the same value; ordering
+	// them by key as well gives such a hash to the least key,
+	// whatever the order of the calls to Add.
+	// 升序排列虚拟节点，哈希值相同时按服务器名排序，使冲突的结果与添加顺序无关
+	sort.Slice(m.points, func(i, j int) bool {
+		a, b := m.points[i], m.points[j]
+		return a.hash < b.hash || a.hash == b.hash && a.item < b.item
+	})
 }
 
-// Get gets the closest item in the hash to the provided key.
-// 获取key 要存到哪个服务器上，返回服务器名称
-func (m *Map) Get(key string) string {
-	if m.IsEmpty() {
-		return ""
+// Remove removes some keys from the hash. The keys left in the hash
+// keep their points, so that only the hashes that were mapped to the
+// removed keys move.
+// 从Map中删除服务器，其余服务器的虚拟节点不变
+func (m *Map) Remove(keys ...string) {
+	removed := false
+	for _, key := range keys {
+		if _, ok := m.items[key]; ok {
+			delete(m.items, key)
+			removed = true
+		}
 	}
+	if !removed {
+		return
+	}
+	points := m.points[:0]
+	for _, p := range m.points {
+		if _, ok := m.items[p.item]; ok {
+			points = append(points, p)
+		}
+	}
+	m.points = points
+}
 
+// search returns the index of the first point clockwise from key.
+// 利用二分查找 找到 hash >= key 哈希值的最小虚拟节点，找不到时回到环首
+func (m *Map) search(key string) int {
 	// 计算 key 对应的哈希值
 	hash := int(m.hash([]byte(key)))
 
 	// Binary search for appropriate replica.
-	// 利用二分查找 找到 m.keys[i] >= hash 的最小虚拟节点
-	// 找不到返回默认 len(m.keys)，否则返回环上的 i 未知，i前面的节点都不满足 >= hash， 后面的节点都满足 >= hash；
-	// 也就是哈希环上顺时针 离这个要存储key 最新的一个缓存服务器
-	idx := sort.Search(len(m.keys),
-		func(i int) bool { return m.keys[i] >= hash })
+	idx := sort.Search(len(m.points),
+		func(i int) bool { return m.points[i].hash >= hash })
 
 	// Means we have cycled back to the first replica.
-	// 如果idx = len(m.keys) 也就是说上面没有找到，将idx 设置成 0
-	if idx == len(m.keys) {
+	if idx == len(m.points) {
 		idx = 0
 	}
+	return idx
+}
+
+// Get gets the closest item in the hash to the provided key.
+// 获取key 要存到哪个服务器上，返回服务器名称
+func (m *Map) Get(key string) string {
+	if m.IsEmpty() {
+		return ""
+	}
 
 	// 返回用来存 key 的服务器
-	return m.hashMap[m.keys[idx]]
+	return m.points[m.search(key)].item
+}
+
+// GetN returns up to n distinct items in the order the hash visits
+// them from the provided key: the item Get returns, then the next ones
+// clockwise. It returns fewer than n items if the hash holds fewer.
+// 按顺时针顺序返回 key 的前 n 个不同服务器，第一个即 Get 的结果
+func (m *Map) GetN(key string, n int) []string {
+	if m.IsEmpty() || n <= 0 {
+		return nil
+	}
+	if n > len(m.items) {
+		n = len(m.items)
+	}
+
+	// 从 key 对应的虚拟节点开始沿环遍历，跳过已出现的服务器
+	idx := m.search(key)
+	items := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(m.points) && len(items) < n; i++ {
+		item := m.points[(idx+i)%len(m.points)].item
+		if !seen[item] {
+			seen[item] = true
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Set replaces the keys of the hash with items, adding each with its
+// weight as AddWeighted does. Keys that stay in the hash with the same
+// weight keep their points.
+// 以 items 替换所有服务器，只增删变化的部分
+func (m *Map) Set(items map[string]float64) {
+	var removed []string
+	for key := range m.items {
+		if _, ok := items[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	m.Remove(removed...)
+
+	// 相同权重的服务器一次性添加，只需排序一次
+	byWeight := make(map[float64][]string)
+	for key, weight := range items {
+		byWeight[weight] = append(byWeight[weight], key)
+	}
+	for weight, keys := range byWeight {
+		m.AddWeighted(weight, keys...)
+	}
+}
+
+// Variance returns the variance of the items' loads, each item being
+// weighted by its number of replicas. An item's share of the keys is
+// the share of the hash space that ends at its points.
+// 每个服务器的占比为环上以其虚拟节点结尾的弧长之和
+func (m *Map) Variance() float64 {
+	if m.IsEmpty() {
+		return 0
+	}
+	shares := make(map[string]float64, len(m.items))
+	prev := int64(m.points[len(m.points)-1].hash) - 1<<32 // 环首的弧从最后一个点开始
+	for _, p := range m.points {
+		shares[p.item] += float64(int64(p.hash)-prev) / (1 << 32)
+		prev = int64(p.hash)
+	}
+	weights := make(map[string]float64, len(m.items))
+	for item, replicas := range m.items {
+		weights[item] = float64(replicas)
+	}
+	return variance(shares, weights)
 }
@@ -18,6 +18,7 @@ package consistenthash
 
 import (
 	"fmt"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -65,6 +66,134 @@ func TestHashing(t *testing.T) {
 
 }
 
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(i)
+	})
+
+	// Replicas at 2, 4, 6, 12, 14, 16, 22, 24, 26.
+	hash.Add("6", "4", "2")
+
+	testCases := []struct {
+		key  string
+		n    int
+		want []string
+	}{
+		{"3", 1, []string{"4"}},
+		{"3", 2, []string{"4", "6"}},
+		{"3", 3, []string{"4", "6", "2"}},
+		{"3", 5, []string{"4", "6", "2"}},
+		{"27", 2, []string{"2", "4"}},
+		{"3", 0, nil},
+	}
+	for _, tc := range testCases {
+		if got := hash.GetN(tc.key, tc.n); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("GetN(%q, %d) = %q; want %q", tc.key, tc.n, got, tc.want)
+		}
+		if tc.n > 0 && hash.GetN(tc.key, tc.n)[0] != hash.Get(tc.key) {
+			t.Errorf("GetN(%q, %d)[0] differs from Get", tc.key, tc.n)
+		}
+	}
+
+	if got := New(3, nil).GetN("a", 2); got != nil {
+		t.Errorf("GetN on an empty hash = %q; want nil", got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("a", "b", "c")
+	want := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		want[key] = hash.Get(key)
+	}
+
+	hash.Add("d")
+	hash.Remove("d")
+	for key, item := range want {
+		if got := hash.Get(key); got != item {
+			t.Errorf("after adding and removing d, %q maps to %q; want %q", key, got, item)
+		}
+	}
+
+	// Only the keys of the removed item move.
+	hash.Remove("b")
+	for key, item := range want {
+		got := hash.Get(key)
+		if item != "b" && got != item {
+			t.Errorf("after removing b, %q moved from %q to %q", key, item, got)
+		}
+		if got == "b" {
+			t.Errorf("after removing b, %q still maps to it", key)
+		}
+	}
+
+	hash.Remove("a", "c", "unknown")
+	if !hash.IsEmpty() || hash.Get("x") != "" {
+		t.Error("hash not empty after removing every item")
+	}
+}
+
+func TestWeights(t *testing.T) {
+	hash := New(100, nil)
+	hash.Add("a")
+	hash.AddWeighted(3, "b")
+	hash.AddWeighted(0.001, "c")
+
+	counts := make(map[string]int)
+	for _, p := range hash.points {
+		counts[p.item]++
+	}
+	if counts["a"] != 100 || counts["b"] != 300 || counts["c"] != 1 {
+		t.Errorf("replicas = %v; want a:100 b:300 c:1", counts)
+	}
+
+	keys := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		keys[hash.Get(strconv.Itoa(i))]++
+	}
+	if ratio := float64(keys["b"]) / float64(keys["a"]); ratio < 2 || ratio > 6 {
+		t.Errorf("b owns %d keys and a %d; want about 3 times as many", keys["b"], keys["a"])
+	}
+
+	// Reweighting replaces the replicas; a zero weight removes.
+	hash.AddWeighted(1, "b")
+	hash.AddWeighted(0, "c")
+	counts = make(map[string]int)
+	for _, p := range hash.points {
+		counts[p.item]++
+	}
+	if len(counts) != 2 || counts["a"] != 100 || counts["b"] != 100 {
+		t.Errorf("replicas after reweighting = %v; want a:100 b:100", counts)
+	}
+}
+
+func TestCollisions(t *testing.T) {
+	// Every replica of every item hashes to the same value.
+	same := func([]byte) uint32 { return 7 }
+	hash1 := New(3, same)
+	hash1.Add("x", "y", "z")
+	hash2 := New(3, same)
+	hash2.Add("z")
+	hash2.Add("y", "x")
+
+	if got1, got2 := hash1.Get("k"), hash2.Get("k"); got1 != "x" || got2 != "x" {
+		t.Errorf("colliding items resolve to %q and %q; want %q for both", got1, got2, "x")
+	}
+	hash1.Remove("x")
+	if got := hash1.Get("k"); got != "y" {
+		t.Errorf("after removing x, Get = %q; want %q", got, "y")
+	}
+	if got, want := hash2.GetN("k", 3), []string{"x", "y", "z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetN = %q; want %q", got, want)
+	}
+}
+
 func TestConsistency(t *testing.T) {
 	hash1 := New(1, nil)
 	hash2 := New(1, nil)
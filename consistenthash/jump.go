@@ -0,0 +1,140 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consistenthash
+
+import (
+	"hash/crc32"
+	"math"
+)
+
+// Jump places keys by Lamping and Veach's jump consistent hash over
+// numbered buckets: the items in name order, each with as many
+// buckets as its weight, rounded. Keys spread evenly, Get needs no
+// memory beyond the bucket list and runs in time logarithmic in the
+// number of buckets. Only buckets added or removed at the end of the
+// list move keys minimally, so Jump suits clusters whose items are
+// numbered shards that grow and shrink at the end; any other change
+// moves many keys.
+// 跳跃一致性哈希：服务器按名称排序编号，只在末尾增删时迁移最少的 key
+type Jump struct {
+	hash Hash
+	// 按名称排序的服务器，权重为 w 的服务器占 w 个连续的桶
+	buckets []string
+	items   []string
+	weights map[string]float64
+}
+
+// NewJump returns an empty Jump placement hashing with fn, or with
+// crc32.ChecksumIEEE if fn is nil.
+func NewJump(fn Hash) *Jump {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Jump{hash: fn, weights: map[string]float64{}}
+}
+
+// Set replaces the items keys are placed on. An item with a positive
+// weight gets at least one bucket.
+func (j *Jump) Set(items map[string]float64) {
+	j.items = weighted(items)
+	j.buckets = j.buckets[:0]
+	j.weights = make(map[string]float64, len(j.items))
+	for _, item := range j.items {
+		n := int(math.Round(items[item]))
+		if n < 1 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			j.buckets = append(j.buckets, item)
+		}
+		j.weights[item] = items[item]
+	}
+}
+
+// IsEmpty returns true if there are no items available.
+func (j *Jump) IsEmpty() bool {
+	return len(j.buckets) == 0
+}
+
+// jump returns the bucket, out of n, of a key whose 64-bit hash is key.
+// 跳跃一致性哈希算法
+func jump(key uint64, n int) int {
+	var b, i int64 = -1, 0
+	for i < int64(n) {
+		b = i
+		key = key*2862933555777941757 + 1
+		i = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Get returns the item of key's bucket.
+func (j *Jump) Get(key string) string {
+	if j.IsEmpty() {
+		return ""
+	}
+	return j.buckets[jump(mix(uint64(j.hash([]byte(key)))), len(j.buckets))]
+}
+
+// GetN returns up to n items for key: the one Get returns, then those
+// of the buckets of key rehashed with successive seeds. If the seeds
+// run out before n distinct items turn up, the list ends with the
+// missing items in name order.
+// 依次以不同的种子重新计算桶，收集不同的服务器
+func (j *Jump) GetN(key string, n int) []string {
+	if j.IsEmpty() || n <= 0 {
+		return nil
+	}
+	if n > len(j.items) {
+		n = len(j.items)
+	}
+	h := mix(uint64(j.hash([]byte(key))))
+	items := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for seed := uint64(0); seed < uint64(4*len(j.buckets)) && len(items) < n; seed++ {
+		k := h
+		if seed > 0 {
+			k = mix(h + seed)
+		}
+		item := j.buckets[jump(k, len(j.buckets))]
+		if !seen[item] {
+			seen[item] = true
+			items = append(items, item)
+		}
+	}
+	for _, item := range j.items {
+		if len(items) == n {
+			break
+		}
+		if !seen[item] {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Variance returns the variance of the items' loads. Each bucket gets
+// an equal share of the keys, so the variance only comes from the
+// rounding of the weights.
+// 每个桶的占比相同，方差只来自权重的取整
+func (j *Jump) Variance() float64 {
+	shares := make(map[string]float64, len(j.items))
+	for _, item := range j.buckets {
+		shares[item] += 1 / float64(len(j.buckets))
+	}
+	return variance(shares, j.weights)
+}
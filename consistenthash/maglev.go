@@ -0,0 +1,170 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consistenthash
+
+import "hash/crc32"
+
+// defaultMaglevSize is the default size of a Maglev lookup table.
+// It should be prime and much larger than the number of items.
+const defaultMaglevSize = 65537
+
+// Maglev places keys with a lookup table filled as described in
+// Google's Maglev paper: each item walks the table in its own
+// permutation, taking turns with the other items to claim free slots,
+// and a key belongs to the item of the slot its hash falls in. Keys
+// spread almost evenly and Get takes constant time, at the cost of
+// rebuilding the table on Set. A change of items moves a few more
+// keys than the minimum.
+// Maglev 查找表：各服务器按各自的排列轮流占据空槽，key 按哈希值查表
+type Maglev struct {
+	hash Hash
+	// 查找表，元素为 items 的下标
+	table   []int32
+	items   []string
+	weights map[string]float64
+}
+
+// NewMaglev returns an empty Maglev placement with a lookup table of
+// size slots, rounded up to a prime, hashing with fn, or with
+// crc32.ChecksumIEEE if fn is nil. If size is zero, it defaults to
+// 65537.
+func NewMaglev(size int, fn Hash) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevSize
+	}
+	for !isPrime(size) {
+		size++
+	}
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Maglev{hash: fn, table: make([]int32, size), weights: map[string]float64{}}
+}
+
+// isPrime reports whether n is a prime number.
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for d := 2; d*d <= n; d++ {
+		if n%d == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Set replaces the items keys are placed on and refills the table.
+// At each turn, an item claims slots in proportion to its weight.
+// 重建查找表：每一轮中服务器按权重占据相应数量的槽
+func (m *Maglev) Set(items map[string]float64) {
+	m.items = weighted(items)
+	m.weights = make(map[string]float64, len(m.items))
+	if len(m.items) == 0 {
+		return
+	}
+	size := uint64(len(m.table))
+	var max float64
+	offsets := make([]uint64, len(m.items))
+	skips := make([]uint64, len(m.items))
+	for i, item := range m.items {
+		w := items[item]
+		m.weights[item] = w
+		if w > max {
+			max = w
+		}
+		h := mix(uint64(m.hash([]byte(item))))
+		offsets[i] = h % size
+		skips[i] = mix(h)%(size-1) + 1
+	}
+
+	for i := range m.table {
+		m.table[i] = -1
+	}
+	next := make([]uint64, len(m.items)) // 各服务器排列中下一个要尝试的位置
+	credits := make([]float64, len(m.items))
+	for filled := uint64(0); filled < size; {
+		for i, item := range m.items {
+			credits[i] += m.weights[item] / max
+			for ; credits[i] >= 1 && filled < size; credits[i]-- {
+				slot := (offsets[i] + next[i]*skips[i]) % size
+				for m.table[slot] >= 0 {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % size
+				}
+				m.table[slot] = int32(i)
+				next[i]++
+				filled++
+			}
+		}
+	}
+}
+
+// IsEmpty returns true if there are no items available.
+func (m *Maglev) IsEmpty() bool {
+	return len(m.items) == 0
+}
+
+// slot returns the slot of the table key falls in.
+func (m *Maglev) slot(key string) int {
+	return int(mix(uint64(m.hash([]byte(key)))) % uint64(len(m.table)))
+}
+
+// Get returns the item of the slot key falls in.
+func (m *Maglev) Get(key string) string {
+	if m.IsEmpty() {
+		return ""
+	}
+	return m.items[m.table[m.slot(key)]]
+}
+
+// GetN returns up to n distinct items of the slots from the one key
+// falls in onwards.
+// 从 key 所在的槽开始向后查找不同的服务器
+func (m *Maglev) GetN(key string, n int) []string {
+	if m.IsEmpty() || n <= 0 {
+		return nil
+	}
+	if n > len(m.items) {
+		n = len(m.items)
+	}
+	items := make([]string, 0, n)
+	seen := make([]bool, len(m.items))
+	start := m.slot(key)
+	for i := 0; i < len(m.table) && len(items) < n; i++ {
+		idx := m.table[(start+i)%len(m.table)]
+		if !seen[idx] {
+			seen[idx] = true
+			items = append(items, m.items[idx])
+		}
+	}
+	return items
+}
+
+// Variance returns the variance of the items' loads, an item's share
+// of the keys being its share of the table's slots.
+// 服务器的占比即其在查找表中所占的槽的比例
+func (m *Maglev) Variance() float64 {
+	if m.IsEmpty() {
+		return 0
+	}
+	shares := make(map[string]float64, len(m.items))
+	for _, idx := range m.table {
+		shares[m.items[idx]] += 1 / float64(len(m.table))
+	}
+	return variance(shares, m.weights)
+}
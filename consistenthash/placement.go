@@ -0,0 +1,105 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consistenthash
+
+import "sort"
+
+// A Placement decides which item, out of a set of weighted items such
+// as the peers of a cache, each key belongs to. Every process given
+// the same items must place keys the same way.
+// 决定每个 key 属于哪个服务器；相同的服务器集合在各进程上必须得到相同的结果
+type Placement interface {
+	// Set replaces the items keys are placed on, each with its
+	// weight. An item gets a share of the keys in proportion to its
+	// weight; an item with a zero or negative weight gets none.
+	Set(items map[string]float64)
+
+	// IsEmpty returns true if there are no items available.
+	IsEmpty() bool
+
+	// Get returns the item key belongs to, or "" if there are none.
+	Get(key string) string
+
+	// GetN returns up to n distinct items in key's order of
+	// preference, starting with the item Get returns.
+	GetN(key string, n int) []string
+
+	// Variance returns the variance of the items' loads, an item's
+	// load being its share of the keys divided by its share of the
+	// total weight. It is 0 if every item gets exactly its share.
+	Variance() float64
+}
+
+// The implementations of Placement.
+var (
+	_ Placement = (*Map)(nil)
+	_ Placement = (*Rendezvous)(nil)
+	_ Placement = (*Jump)(nil)
+	_ Placement = (*Maglev)(nil)
+)
+
+// variance returns the variance of the loads of the items of weights,
+// given the share of the keys each of them gets.
+// 计算负载方差：负载 = 实际占比 / 权重占比
+func variance(shares, weights map[string]float64) float64 {
+	var total float64
+	n := 0
+	for _, w := range weights {
+		if w > 0 {
+			total += w
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	var sum float64
+	for item, w := range weights {
+		if w > 0 {
+			d := shares[item]/(w/total) - 1
+			sum += d * d
+		}
+	}
+	return sum / float64(n)
+}
+
+// weighted returns the items of items with a positive weight, sorted,
+// so that the placements built from them don't depend on map order.
+// 返回权重为正的服务器，按名称排序
+func weighted(items map[string]float64) []string {
+	var names []string
+	for item, w := range items {
+		if w > 0 {
+			names = append(names, item)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mix scrambles the bits of x (the splitmix64 finalizer), so that
+// nearby inputs, such as the 32-bit hashes of similar keys, give
+// unrelated outputs.
+// 打散 x 的各个比特
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
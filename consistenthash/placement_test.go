@@ -0,0 +1,155 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consistenthash
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+var placements = []struct {
+	name string
+	new  func() Placement
+}{
+	{"ring", func() Placement { return New(50, nil) }},
+	{"rendezvous", func() Placement { return NewRendezvous(nil) }},
+	{"jump", func() Placement { return NewJump(nil) }},
+	{"maglev", func() Placement { return NewMaglev(0, nil) }},
+}
+
+func nodes(n int) map[string]float64 {
+	items := make(map[string]float64, n)
+	for i := 0; i < n; i++ {
+		items[fmt.Sprintf("node-%d", i)] = 1
+	}
+	return items
+}
+
+func TestPlacementGet(t *testing.T) {
+	for _, tc := range placements {
+		p1, p2 := tc.new(), tc.new()
+		if !p1.IsEmpty() || p1.Get("k") != "" || p1.GetN("k", 2) != nil {
+			t.Errorf("%s: empty placement returned items", tc.name)
+		}
+		p1.Set(nodes(5))
+		// p2 gets there through other sets of items.
+		p2.Set(nodes(8))
+		p2.Set(nodes(5))
+		for i := 0; i < 1000; i++ {
+			key := strconv.Itoa(i)
+			if g1, g2 := p1.Get(key), p2.Get(key); g1 != g2 {
+				t.Fatalf("%s: Get(%q) = %q and %q for the same items", tc.name, key, g1, g2)
+			}
+			items := p1.GetN(key, 3)
+			if len(items) != 3 || items[0] != p1.Get(key) || items[1] == items[0] || items[2] == items[0] || items[2] == items[1] {
+				t.Fatalf("%s: GetN(%q, 3) = %q; want 3 distinct items starting with %q", tc.name, key, items, p1.Get(key))
+			}
+			if !reflect.DeepEqual(items, p2.GetN(key, 3)) {
+				t.Fatalf("%s: GetN(%q, 3) differs for the same items", tc.name, key)
+			}
+		}
+		if got := p1.GetN("k", 10); len(got) != 5 {
+			t.Errorf("%s: GetN(k, 10) = %q; want all 5 items", tc.name, got)
+		}
+	}
+}
+
+func TestPlacementRemove(t *testing.T) {
+	for _, tc := range placements {
+		p := tc.new()
+		p.Set(nodes(5))
+		before := make(map[string]string)
+		for i := 0; i < 1000; i++ {
+			key := strconv.Itoa(i)
+			before[key] = p.Get(key)
+		}
+		// Jump moves few keys only when the last item goes.
+		p.Set(map[string]float64{"node-0": 1, "node-1": 1, "node-2": 1, "node-3": 1, "node-4": 0})
+		moved := 0
+		for key, item := range before {
+			got := p.Get(key)
+			if got == "node-4" {
+				t.Fatalf("%s: %q still maps to the removed item", tc.name, key)
+			}
+			if item != "node-4" && got != item {
+				moved++
+			}
+		}
+		// Maglev moves a few more keys than the minimum.
+		if moved > 50 || tc.name != "maglev" && moved > 0 {
+			t.Errorf("%s: %d keys of the remaining items moved", tc.name, moved)
+		}
+	}
+}
+
+func TestPlacementVariance(t *testing.T) {
+	for _, tc := range placements {
+		p := tc.new()
+		if v := p.Variance(); v != 0 {
+			t.Errorf("%s: Variance of no items = %v; want 0", tc.name, v)
+		}
+		p.Set(nodes(20))
+		v := p.Variance()
+		if v <= 0 && tc.name == "ring" || v > 0.1 {
+			t.Errorf("%s: Variance of 20 items = %v", tc.name, v)
+		}
+		if tc.name != "ring" && v > 0.01 {
+			t.Errorf("%s: Variance of 20 items = %v; want under 0.01", tc.name, v)
+		}
+
+		// Keys follow the weights; the ring only roughly.
+		p.Set(map[string]float64{"a": 1, "b": 2, "c": 3})
+		if v := p.Variance(); v > 0.2 || tc.name != "ring" && v > 0.05 {
+			t.Errorf("%s: Variance of weighted items = %v; want under 0.05", tc.name, v)
+		}
+		counts := make(map[string]int)
+		for i := 0; i < 6000; i++ {
+			counts[p.Get(fmt.Sprintf("key-%d", i))]++
+		}
+		if counts["c"] < 2*counts["a"] {
+			t.Errorf("%s: c of weight 3 got %d keys, a of weight 1 %d", tc.name, counts["c"], counts["a"])
+		}
+	}
+}
+
+func TestVariance(t *testing.T) {
+	weights := map[string]float64{"a": 1, "b": 3}
+	if v := variance(map[string]float64{"a": 0.25, "b": 0.75}, weights); v != 0 {
+		t.Errorf("variance of fair shares = %v; want 0", v)
+	}
+	// Loads of 2 and 2/3.
+	if v, want := variance(map[string]float64{"a": 0.5, "b": 0.5}, weights), (1+1.0/9)/2; v < want-1e-9 || v > want+1e-9 {
+		t.Errorf("variance = %v; want %v", v, want)
+	}
+}
+
+func BenchmarkPlacementGet(b *testing.B) {
+	for _, tc := range placements {
+		for _, n := range []int{8, 128, 512} {
+			b.Run(fmt.Sprintf("%s/%d", tc.name, n), func(b *testing.B) {
+				p := tc.new()
+				p.Set(nodes(n))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					p.Get(strconv.Itoa(i))
+				}
+			})
+		}
+	}
+}
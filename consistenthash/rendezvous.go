@@ -0,0 +1,149 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consistenthash
+
+import (
+	"hash/crc32"
+	"math"
+	"sort"
+)
+
+// rendezvousSamples is the number of evenly spaced key hashes
+// Rendezvous.Variance places to estimate the items' shares.
+const rendezvousSamples = 1 << 14
+
+// Rendezvous places keys by rendezvous, or highest random weight,
+// hashing: each key goes to the item with the highest score for it,
+// derived from the hashes of both. Keys spread evenly without
+// replicas, and only the keys of an added or removed item move, but
+// Get costs time proportional to the number of items.
+// 最高随机权重（HRW）哈希：key 属于对其得分最高的服务器
+type Rendezvous struct {
+	hash Hash
+	// 按名称排序的服务器
+	items []string
+	// 服务器名的哈希值，与 items 一一对应
+	hashes  []uint32
+	weights map[string]float64
+	// 所有服务器权重相同时，直接比较哈希值，省去对数运算
+	uniform bool
+}
+
+// NewRendezvous returns an empty Rendezvous placement hashing with
+// fn, or with crc32.ChecksumIEEE if fn is nil.
+func NewRendezvous(fn Hash) *Rendezvous {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Rendezvous{hash: fn, weights: map[string]float64{}}
+}
+
+// Set replaces the items keys are placed on.
+func (r *Rendezvous) Set(items map[string]float64) {
+	r.items = weighted(items)
+	r.hashes = make([]uint32, len(r.items))
+	r.weights = make(map[string]float64, len(r.items))
+	r.uniform = true
+	for i, item := range r.items {
+		r.hashes[i] = r.hash([]byte(item))
+		r.weights[item] = items[item]
+		if items[item] != items[r.items[0]] {
+			r.uniform = false
+		}
+	}
+}
+
+// IsEmpty returns true if there are no items available.
+func (r *Rendezvous) IsEmpty() bool {
+	return len(r.items) == 0
+}
+
+// score returns the score of the i'th item for a key hashing to h.
+// Weighted scores follow Schindelhauer and Schomaker's logarithmic
+// method, so that each item wins keys in proportion to its weight.
+// With equal weights, the scores compare as the pseudo-random numbers
+// they derive from, which are returned instead.
+// 得分 = -weight / ln(x)，x 为 (0, 1) 内的伪随机数
+func (r *Rendezvous) score(h uint32, i int) float64 {
+	x := mix(uint64(h)<<32 | uint64(r.hashes[i]))
+	if r.uniform {
+		return float64(x >> 11)
+	}
+	u := (float64(x>>11) + 0.5) / (1 << 53)
+	return -r.weights[r.items[i]] / math.Log(u)
+}
+
+// get returns the index of the item a key hashing to h belongs to.
+func (r *Rendezvous) get(h uint32) int {
+	best, bestScore := 0, r.score(h, 0)
+	for i := 1; i < len(r.items); i++ {
+		if s := r.score(h, i); s > bestScore {
+			best, bestScore = i, s
+		}
+	}
+	return best
+}
+
+// Get returns the item with the highest score for key.
+func (r *Rendezvous) Get(key string) string {
+	if r.IsEmpty() {
+		return ""
+	}
+	return r.items[r.get(r.hash([]byte(key)))]
+}
+
+// GetN returns up to n items in decreasing order of their score for key.
+// 按得分从高到低返回前 n 个服务器
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if r.IsEmpty() || n <= 0 {
+		return nil
+	}
+	h := r.hash([]byte(key))
+	order := make([]int, len(r.items))
+	scores := make([]float64, len(r.items))
+	for i := range order {
+		order[i] = i
+		scores[i] = r.score(h, i)
+	}
+	// 得分相同时按名称排序，保证结果确定
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+	if n > len(order) {
+		n = len(order)
+	}
+	items := make([]string, n)
+	for i := range items {
+		items[i] = r.items[order[i]]
+	}
+	return items
+}
+
+// Variance returns the variance of the items' loads, estimated from
+// the placement of rendezvousSamples evenly spaced key hashes.
+// 用均匀分布的哈希值抽样估计各服务器的占比
+func (r *Rendezvous) Variance() float64 {
+	if r.IsEmpty() {
+		return 0
+	}
+	shares := make(map[string]float64, len(r.items))
+	for i := 0; i < rendezvousSamples; i++ {
+		h := uint32(uint64(i) << 32 / rendezvousSamples)
+		shares[r.items[r.get(h)]] += 1.0 / rendezvousSamples
+	}
+	return variance(shares, r.weights)
+}
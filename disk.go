@@ -0,0 +1,365 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+)
+
+// diskFileExt is the extension of the files of a diskCache.
+const diskFileExt = ".gcache"
+
+// diskHeaderSize is the size of the header of a diskCache file: the
+// CRC-32C of the rest of the file, the expiration time in Unix
+// nanoseconds (zero if none) and the length of the key. The key and
+// the value follow.
+const diskHeaderSize = 4 + 8 + 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// diskQueueLen is the number of entries that may wait for the writer
+// of a diskCache. Entries spilled while the queue is full are dropped.
+const diskQueueLen = 256
+
+var errDiskCorrupt = errors.New("groupcache: corrupt disk cache file")
+
+// writeFile writes disk cache files; tests replace it.
+var writeFile = os.WriteFile
+
+// diskCache is a cache tier on local disk, holding the entries
+// evicted from a Group's main cache, up to maxBytes of files. Each
+// entry is a file in dir; the index of the files is kept in memory
+// only, so files left by a previous process are deleted.
+//
+// Files are written by a background goroutine, so that evictions
+// don't wait for the disk. Entries waiting for it are served from
+// memory.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+	errors   *AtomicInt // counts failed writes
+
+	initOnce sync.Once
+	initErr  error
+
+	seq atomic.Uint64 // for file names
+
+	queue   chan *diskSpill
+	writing sync.WaitGroup // of queued spills
+
+	mu         sync.Mutex
+	index      *lru.Cache            // of *diskEntry, by key
+	pending    map[string]*diskSpill // queued spills, by key
+	closed     bool                  // whether queue is closed
+	nbytes     int64                 // of all files
+	nhit, nget int64
+	nevict     int64
+}
+
+type diskEntry struct {
+	file string
+	size int64
+}
+
+// diskSpill is an entry waiting to be written to disk.
+type diskSpill struct {
+	key   string
+	value ByteView
+}
+
+func (d *diskCache) init() error {
+	d.initOnce.Do(func() {
+		if d.initErr = os.MkdirAll(d.dir, 0700); d.initErr != nil {
+			return
+		}
+		stale, _ := filepath.Glob(filepath.Join(d.dir, "*"+diskFileExt))
+		for _, f := range stale {
+			os.Remove(f)
+		}
+		d.index = &lru.Cache{
+			OnEvicted: func(key lru.Key, value interface{}) {
+				e := value.(*diskEntry)
+				d.nbytes -= e.size
+				os.Remove(e.file)
+			},
+		}
+		d.pending = make(map[string]*diskSpill)
+		d.queue = make(chan *diskSpill, diskQueueLen)
+		go d.writer()
+	})
+	return d.initErr
+}
+
+// add queues key's value to be written to disk. It returns false,
+// dropping the value, if diskQueueLen entries are already waiting.
+func (d *diskCache) add(key string, value ByteView) (bool, error) {
+	if err := d.init(); err != nil {
+		return false, err
+	}
+	s := &diskSpill{key: key, value: value}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false, nil
+	}
+	d.writing.Add(1)
+	select {
+	case d.queue <- s:
+		d.pending[key] = s
+		return true, nil
+	default:
+		d.writing.Done()
+		return false, nil
+	}
+}
+
+// writer writes the queued spills until the queue is closed.
+func (d *diskCache) writer() {
+	for s := range d.queue {
+		if err := d.write(s); err != nil && d.errors != nil {
+			d.errors.Add(1)
+		}
+		d.writing.Done()
+	}
+}
+
+// write writes s to disk, evicting the least recently used files to
+// stay within maxBytes. Spills removed or replaced while queued are
+// skipped.
+func (d *diskCache) write(s *diskSpill) error {
+	if !d.isPending(s) {
+		return nil
+	}
+	b := encodeDiskEntry(s.key, s.value)
+	if int64(len(b)) > d.maxBytes {
+		d.mu.Lock()
+		d.unqueue(s)
+		d.mu.Unlock()
+		return nil
+	}
+	name := fmt.Sprintf("%016x-%d%s", keyHash(s.key), d.seq.Add(1), diskFileExt)
+	file := filepath.Join(d.dir, name)
+	err := writeFile(file, b, 0600)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil || d.pending[s.key] != s {
+		d.unqueue(s)
+		os.Remove(file)
+		return err
+	}
+	d.unqueue(s)
+	d.index.Remove(s.key)
+	d.index.Add(s.key, &diskEntry{file: file, size: int64(len(b))})
+	d.nbytes += int64(len(b))
+	for d.nbytes > d.maxBytes {
+		d.index.RemoveOldest()
+		d.nevict++
+	}
+	return nil
+}
+
+func (d *diskCache) isPending(s *diskSpill) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pending[s.key] == s
+}
+
+// unqueue forgets s, unless key was spilled again since. d.mu must be
+// held.
+func (d *diskCache) unqueue(s *diskSpill) {
+	if d.pending[s.key] == s {
+		delete(d.pending, s.key)
+	}
+}
+
+// flush waits until the spills queued so far are written.
+func (d *diskCache) flush() {
+	d.writing.Wait()
+}
+
+// get reads key's value from disk. A file that fails its checksum is
+// deleted and reported as errDiskCorrupt.
+func (d *diskCache) get(key string) (value ByteView, ok bool, err error) {
+	if err := d.init(); err != nil {
+		return ByteView{}, false, err
+	}
+	d.mu.Lock()
+	d.nget++
+	if s, ok := d.pending[key]; ok {
+		d.nhit++
+		d.mu.Unlock()
+		return s.value, true, nil
+	}
+	ei, ok := d.index.Get(key)
+	d.mu.Unlock()
+	if !ok {
+		return
+	}
+	e := ei.(*diskEntry)
+	b, err := os.ReadFile(e.file)
+	if err == nil {
+		value, err = decodeDiskEntry(b, key)
+	}
+	if err != nil {
+		d.removeEntry(key, e)
+		return ByteView{}, false, err
+	}
+	d.mu.Lock()
+	d.nhit++
+	d.mu.Unlock()
+	return value, true, nil
+}
+
+// remove deletes key's file, if any, and drops its queued spill.
+func (d *diskCache) remove(key string) {
+	if d.init() != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, key)
+	d.index.Remove(key)
+}
+
+// removeEntry deletes e, unless key was written again since.
+func (d *diskCache) removeEntry(key string, e *diskEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cur, ok := d.index.Get(key); ok && cur == e {
+		d.index.Remove(key)
+	}
+}
+
+// clear deletes all files and drops the queued spills.
+func (d *diskCache) clear() {
+	if d.init() != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending = make(map[string]*diskSpill)
+	d.index.Clear()
+	d.nbytes = 0
+}
+
+// close stops the writer of d and clears d.
+func (d *diskCache) close() {
+	if d.init() != nil {
+		return
+	}
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		d.pending = make(map[string]*diskSpill)
+		close(d.queue)
+	}
+	d.mu.Unlock()
+	d.flush()
+	d.clear()
+}
+
+func (d *diskCache) stats() CacheStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st := CacheStats{
+		TargetBytes: d.maxBytes,
+		Bytes:       d.nbytes,
+		Gets:        d.nget,
+		Hits:        d.nhit,
+		Evictions:   d.nevict,
+	}
+	if d.index != nil {
+		st.Items = int64(d.index.Len())
+	}
+	return st
+}
+
+func encodeDiskEntry(key string, value ByteView) []byte {
+	b := make([]byte, diskHeaderSize+len(key)+value.Len())
+	var expire int64
+	if e := value.Expire(); !e.IsZero() {
+		expire = e.UnixNano()
+	}
+	binary.LittleEndian.PutUint64(b[4:], uint64(expire))
+	binary.LittleEndian.PutUint32(b[12:], uint32(len(key)))
+	copy(b[diskHeaderSize:], key)
+	value.Copy(b[diskHeaderSize+len(key):])
+	binary.LittleEndian.PutUint32(b, crc32.Checksum(b[4:], castagnoli))
+	return b
+}
+
+func decodeDiskEntry(b []byte, key string) (ByteView, error) {
+	if len(b) < diskHeaderSize || binary.LittleEndian.Uint32(b) != crc32.Checksum(b[4:], castagnoli) {
+		return ByteView{}, errDiskCorrupt
+	}
+	klen := int(binary.LittleEndian.Uint32(b[12:]))
+	if klen > len(b)-diskHeaderSize || string(b[diskHeaderSize:diskHeaderSize+klen]) != key {
+		return ByteView{}, errDiskCorrupt
+	}
+	value := ByteView{b: b[diskHeaderSize+klen:]}
+	if expire := int64(binary.LittleEndian.Uint64(b[4:])); expire != 0 {
+		value.e = time.Unix(0, expire)
+	}
+	return value, nil
+}
+
+// lookupDisk returns key's value from the disk tier, moving it back
+// into the main cache.
+func (g *Group) lookupDisk(key string) (value ByteView, ok bool) {
+	if g.disk == nil {
+		return
+	}
+	value, ok, err := g.disk.get(key)
+	if err != nil {
+		g.Stats.DiskErrors.Add(1)
+		return
+	}
+	if !ok {
+		return
+	}
+	if value.expired(nowFunc().Add(-g.opts.StaleWhileRevalidate)) {
+		g.disk.remove(key)
+		return ByteView{}, false
+	}
+	g.populateCache(key, value, &g.mainCache)
+	return value, true
+}
+
+// spill queues an entry evicted from the main cache to be written to
+// the disk tier.
+func (g *Group) spill(key string, value ByteView) {
+	if g.disk == nil || value.expired(nowFunc().Add(-g.opts.StaleWhileRevalidate)) {
+		return
+	}
+	ok, err := g.disk.add(key, value)
+	if err != nil {
+		g.Stats.DiskErrors.Add(1)
+	} else if !ok {
+		g.Stats.DiskDrops.Add(1)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newDiskTestGroup(t *testing.T, name string, diskBytes int64) (g *Group, loads map[string]int, cleanup func()) {
+	dir, err := os.MkdirTemp("", "groupcache-disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loads = make(map[string]int)
+	g = DefaultRegistry.newGroup(name, 100, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		loads[key]++
+		if key == "expiring" {
+			dest.SetExpire(nowFunc().Add(time.Minute))
+		}
+		return dest.SetString("value-of-" + key)
+	}), NoPeers{}, &GroupOptions{DiskDir: dir, DiskBytes: diskBytes})
+	return g, loads, func() {
+		g.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func diskGet(t *testing.T, g *Group, key string) {
+	t.Helper()
+	var s string
+	if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "value-of-" + key; s != want {
+		t.Fatalf("Get(%q) = %q; want %q", key, s, want)
+	}
+}
+
+func TestDiskTier(t *testing.T) {
+	g, loads, cleanup := newDiskTestGroup(t, "TestDiskTier-group", 1<<20)
+	defer cleanup()
+
+	// Each entry takes 19 bytes in memory, so 5 fit; the rest spill.
+	for i := 0; i < 20; i++ {
+		diskGet(t, g, fmt.Sprintf("key%02d", i))
+	}
+	g.disk.flush()
+	if st := g.CacheStats(DiskCache); st.Items != 15 {
+		t.Errorf("disk tier holds %d items; want 15", st.Items)
+	}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		diskGet(t, g, key)
+		if loads[key] != 1 {
+			t.Errorf("%q loaded %d times; want 1", key, loads[key])
+		}
+	}
+	if st := g.CacheStats(DiskCache); st.Hits != 20 {
+		t.Errorf("disk tier hits = %d; want 20", st.Hits)
+	}
+
+	// Removing a key also removes it from disk.
+	diskGet(t, g, "key99")
+	g.Remove(dummyCtx, "key00")
+	diskGet(t, g, "key00")
+	if loads["key00"] != 2 {
+		t.Errorf("removed key loaded %d times; want 2", loads["key00"])
+	}
+}
+
+func TestDiskTierBudget(t *testing.T) {
+	g, _, cleanup := newDiskTestGroup(t, "TestDiskTierBudget-group", 200)
+	defer cleanup()
+	for i := 0; i < 50; i++ {
+		diskGet(t, g, fmt.Sprintf("key%02d", i))
+	}
+	g.disk.flush()
+	st := g.CacheStats(DiskCache)
+	if st.Bytes > 200 || st.Evictions == 0 {
+		t.Errorf("disk stats = %+v; want at most 200 bytes and some evictions", st)
+	}
+	files, _ := filepath.Glob(filepath.Join(g.opts.DiskDir, "*"+diskFileExt))
+	if int64(len(files)) != st.Items {
+		t.Errorf("%d files on disk; want %d", len(files), st.Items)
+	}
+}
+
+func TestDiskTierCorruption(t *testing.T) {
+	g, loads, cleanup := newDiskTestGroup(t, "TestDiskTierCorruption-group", 1<<20)
+	defer cleanup()
+	for i := 0; i < 10; i++ {
+		diskGet(t, g, fmt.Sprintf("key%02d", i))
+	}
+	g.disk.flush()
+	files, _ := filepath.Glob(filepath.Join(g.opts.DiskDir, "*"+diskFileExt))
+	if len(files) == 0 {
+		t.Fatal("no files on disk")
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b[len(b)-1] ^= 0xff
+		if err := os.WriteFile(f, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		diskGet(t, g, fmt.Sprintf("key%02d", i))
+	}
+	if n := g.Stats.DiskErrors.Get(); n != int64(len(files)) {
+		t.Errorf("DiskErrors = %d; want %d", n, len(files))
+	}
+	reloads := 0
+	for _, n := range loads {
+		reloads += n - 1
+	}
+	if reloads != len(files) {
+		t.Errorf("%d keys reloaded; want %d", reloads, len(files))
+	}
+}
+
+func TestDiskTierExpiration(t *testing.T) {
+	clock, restore := useFakeClock(time.Unix(1000, 0))
+	defer restore()
+	g, loads, cleanup := newDiskTestGroup(t, "TestDiskTierExpiration-group", 1<<20)
+	defer cleanup()
+	diskGet(t, g, "expiring")
+	for i := 0; i < 10; i++ {
+		diskGet(t, g, fmt.Sprintf("key%02d", i))
+	}
+	g.disk.flush()
+	if st := g.CacheStats(DiskCache); st.Items == 0 {
+		t.Fatal("nothing spilled to disk")
+	}
+	clock.Advance(time.Minute)
+	diskGet(t, g, "expiring")
+	if loads["expiring"] != 2 {
+		t.Errorf("expired key loaded %d times; want 2", loads["expiring"])
+	}
+}
+
+func TestDiskTierQueueFull(t *testing.T) {
+	unblock := make(chan struct{})
+	writeFile = func(name string, data []byte, perm os.FileMode) error {
+		<-unblock
+		return os.WriteFile(name, data, perm)
+	}
+	defer func() { writeFile = os.WriteFile }()
+	g, loads, cleanup := newDiskTestGroup(t, "TestDiskTierQueueFull-group", 1<<20)
+	defer cleanup()
+
+	// The writer blocks on the first spill, so diskQueueLen+1 are
+	// taken and the rest dropped, without blocking Get.
+	n := diskQueueLen + 100
+	for i := 0; i < n; i++ {
+		diskGet(t, g, fmt.Sprintf("key%03d", i))
+	}
+	spills := int64(n - 5)
+	drops := g.Stats.DiskDrops.Get()
+	if want := spills - diskQueueLen - 1; drops < want {
+		t.Errorf("DiskDrops = %d; want at least %d", drops, want)
+	}
+
+	// Queued entries are served before they reach the disk.
+	diskGet(t, g, "key000")
+	if loads["key000"] != 1 {
+		t.Errorf("queued key loaded %d times; want 1", loads["key000"])
+	}
+
+	close(unblock)
+	g.disk.flush()
+	if st := g.CacheStats(DiskCache); st.Items != spills-drops {
+		t.Errorf("disk tier holds %d items; want %d", st.Items, spills-drops)
+	}
+}
+
+func TestDiskTierRemoveQueued(t *testing.T) {
+	unblock := make(chan struct{})
+	writeFile = func(name string, data []byte, perm os.FileMode) error {
+		<-unblock
+		return os.WriteFile(name, data, perm)
+	}
+	defer func() { writeFile = os.WriteFile }()
+	g, loads, cleanup := newDiskTestGroup(t, "TestDiskTierRemoveQueued-group", 1<<20)
+	defer cleanup()
+
+	for i := 0; i < 10; i++ {
+		diskGet(t, g, fmt.Sprintf("key%02d", i))
+	}
+	// key00 and key01 are queued, or being written, when removed.
+	g.Remove(dummyCtx, "key00")
+	g.Remove(dummyCtx, "key01")
+	close(unblock)
+	g.disk.flush()
+	if st := g.CacheStats(DiskCache); st.Items != 3 {
+		t.Errorf("disk tier holds %d items; want 3", st.Items)
+	}
+	diskGet(t, g, "key00")
+	if loads["key00"] != 2 {
+		t.Errorf("removed key loaded %d times; want 2", loads["key00"])
+	}
+}
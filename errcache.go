@@ -0,0 +1,128 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+)
+
+// maxErrorEntries bounds the number of keys whose errors a Group
+// remembers.
+const maxErrorEntries = 1024
+
+// errorCache is a bounded cache of recent load errors, keyed by the
+// key whose load failed. Entries outlive their expiration so that
+// consecutive failures of a key can back off; a successful load
+// forgets them.
+type errorCache struct {
+	mu  sync.Mutex
+	lru *lru.Cache // of *errorEntry
+}
+
+type errorEntry struct {
+	err      error
+	expire   time.Time
+	failures int // consecutive failures cached for the key
+}
+
+// get returns the unexpired error remembered for key, if any.
+func (c *errorCache) get(key string, now time.Time) (err error, expire time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return
+	}
+	ei, ok := c.lru.Get(key)
+	if !ok {
+		return
+	}
+	e := ei.(*errorEntry)
+	if !now.Before(e.expire) {
+		return nil, time.Time{}, false
+	}
+	return e.err, e.expire, true
+}
+
+// add remembers err for key. The first failure is remembered for
+// ttl; each consecutive one for twice as long as the previous, up
+// to maxTTL. It returns the time at which err expires.
+func (c *errorCache) add(key string, err error, ttl, maxTTL time.Duration, now time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entryLocked(key)
+	for i := 0; i < e.failures && ttl < maxTTL; i++ {
+		ttl *= 2
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	e.err = err
+	e.expire = now.Add(ttl)
+	e.failures++
+	return e.expire
+}
+
+// addUntil remembers err for key until expire, as decided by the
+// key's owner.
+func (c *errorCache) addUntil(key string, err error, expire time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entryLocked(key)
+	e.err = err
+	e.expire = expire
+}
+
+func (c *errorCache) entryLocked(key string) *errorEntry {
+	if c.lru == nil {
+		c.lru = lru.New(maxErrorEntries)
+	}
+	if ei, ok := c.lru.Get(key); ok {
+		return ei.(*errorEntry)
+	}
+	e := new(errorEntry)
+	c.lru.Add(key, e)
+	return e
+}
+
+// remove forgets any error remembered for key.
+func (c *errorCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru != nil {
+		c.lru.Remove(key)
+	}
+}
+
+// cacheableError is the default GroupOptions.CacheError. It caches
+// every error except those caused by the caller's context, which
+// say nothing about the key.
+func cacheableError(key string, err error) bool {
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// A remoteGetterError is an error that the key's owner returned from
+// its Getter. Loading the key locally or on a fallback of the owner
+// would only repeat the failure, so it is returned to the caller as
+// is.
+type remoteGetterError string
+
+func (e remoteGetterError) Error() string { return string(e) }
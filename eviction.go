@@ -0,0 +1,177 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import "container/list"
+
+// An EvictionPolicy holds the entries of one of a Group's caches and
+// decides which of them to drop when the cache is over its byte
+// budget. The cache does the byte accounting and serializes all
+// calls, so implementations need not be safe for concurrent use.
+//
+// NewLRU, NewLFU, NewARC and NewTinyLFU return the policies that
+// ship with this package. See GroupOptions.NewEvictionPolicy.
+type EvictionPolicy interface {
+	// Add inserts key, or replaces its value, and returns the
+	// value it replaced, if any. Adding counts as an access.
+	Add(key string, value ByteView) (old ByteView, replaced bool)
+
+	// Get returns key's value and records the access.
+	Get(key string) (value ByteView, ok bool)
+
+	// Remove drops key and returns its value, if it was present.
+	Remove(key string) (value ByteView, ok bool)
+
+	// Evict drops the entry the policy values the least and
+	// returns it. ok is false if the policy holds no entries.
+	Evict() (key string, value ByteView, ok bool)
+
+	// Len returns the number of entries held.
+	Len() int
+
+	// Range calls fn for each entry, roughly from the one the
+	// policy would evict first to the one it would evict last,
+	// until fn returns false. It must not change the policy's
+	// state. Adding the entries in that order to an empty policy
+	// should restore their order.
+	Range(fn func(key string, value ByteView) bool)
+}
+
+// A SharedGetter is an EvictionPolicy whose lookups may run
+// concurrently with each other. Caches serve lookups of such a
+// policy under a read lock, so that hits don't contend.
+type SharedGetter interface {
+	EvictionPolicy
+
+	// GetShared is like Get, but may be called concurrently with
+	// other calls of GetShared, though not with other methods.
+	GetShared(key string) (value ByteView, ok bool)
+}
+
+// NewLRU returns an EvictionPolicy that evicts the least recently
+// used entry. It is the default.
+func NewLRU() EvictionPolicy {
+	return &lruPolicy{l: newLRUList()}
+}
+
+type lruPolicy struct {
+	l lruList
+}
+
+func (p *lruPolicy) Add(key string, value ByteView) (old ByteView, replaced bool) {
+	if e, ok := p.l.get(key); ok {
+		old, e.value = e.value, value
+		p.l.moveToFront(e)
+		return old, true
+	}
+	p.l.pushFront(&policyEntry{key: key, value: value})
+	return
+}
+
+func (p *lruPolicy) Get(key string) (value ByteView, ok bool) {
+	e, ok := p.l.get(key)
+	if !ok {
+		return
+	}
+	p.l.moveToFront(e)
+	return e.value, true
+}
+
+func (p *lruPolicy) Remove(key string) (value ByteView, ok bool) {
+	e, ok := p.l.get(key)
+	if !ok {
+		return
+	}
+	p.l.remove(e)
+	return e.value, true
+}
+
+func (p *lruPolicy) Evict() (key string, value ByteView, ok bool) {
+	e, ok := p.l.oldest()
+	if !ok {
+		return
+	}
+	p.l.remove(e)
+	return e.key, e.value, true
+}
+
+func (p *lruPolicy) Len() int { return p.l.len() }
+
+func (p *lruPolicy) Range(fn func(key string, value ByteView) bool) {
+	p.l.rangeOldest(fn)
+}
+
+// policyEntry is an entry of an lruList.
+type policyEntry struct {
+	key   string
+	value ByteView
+	elem  *list.Element
+}
+
+// lruList is a list of entries in recency order, indexed by key.
+// It is the building block of the policies in this package.
+type lruList struct {
+	ll    *list.List // of *policyEntry, most recent first
+	items map[string]*policyEntry
+}
+
+func newLRUList() lruList {
+	return lruList{ll: list.New(), items: make(map[string]*policyEntry)}
+}
+
+// get returns key's entry without changing its recency.
+func (l *lruList) get(key string) (*policyEntry, bool) {
+	e, ok := l.items[key]
+	return e, ok
+}
+
+func (l *lruList) pushFront(e *policyEntry) {
+	e.elem = l.ll.PushFront(e)
+	l.items[e.key] = e
+}
+
+func (l *lruList) moveToFront(e *policyEntry) {
+	l.ll.MoveToFront(e.elem)
+}
+
+func (l *lruList) remove(e *policyEntry) {
+	l.ll.Remove(e.elem)
+	delete(l.items, e.key)
+}
+
+// oldest returns the least recently used entry.
+func (l *lruList) oldest() (*policyEntry, bool) {
+	back := l.ll.Back()
+	if back == nil {
+		return nil, false
+	}
+	return back.Value.(*policyEntry), true
+}
+
+func (l *lruList) len() int { return l.ll.Len() }
+
+// rangeOldest calls fn for each entry, least recently used first,
+// until fn returns false. It returns false if fn did.
+func (l *lruList) rangeOldest(fn func(key string, value ByteView) bool) bool {
+	for e := l.ll.Back(); e != nil; e = e.Prev() {
+		pe := e.Value.(*policyEntry)
+		if !fn(pe.key, pe.value) {
+			return false
+		}
+	}
+	return true
+}
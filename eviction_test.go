@@ -0,0 +1,272 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+var evictionPolicies = []struct {
+	name          string
+	new           func() EvictionPolicy
+	scanResistant bool
+}{
+	{"LRU", NewLRU, false},
+	{"LFU", NewLFU, true},
+	{"ARC", NewARC, true},
+	{"TinyLFU", NewTinyLFU, true},
+	{"CLOCK", NewCLOCK, false},
+}
+
+func TestEvictionPolicyBasics(t *testing.T) {
+	for _, tt := range evictionPolicies {
+		p := tt.new()
+		if _, _, ok := p.Evict(); ok {
+			t.Errorf("%s: Evict on empty policy succeeded", tt.name)
+		}
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprint(i)
+			if _, replaced := p.Add(key, ByteView{s: "v" + key}); replaced {
+				t.Errorf("%s: Add(%q) replaced a value", tt.name, key)
+			}
+		}
+		if old, replaced := p.Add("3", ByteView{s: "new"}); !replaced || old.String() != "v3" {
+			t.Errorf("%s: Add of existing key = %q, %v; want v3, true", tt.name, old, replaced)
+		}
+		if v, ok := p.Get("3"); !ok || v.String() != "new" {
+			t.Errorf("%s: Get(3) = %q, %v; want new, true", tt.name, v, ok)
+		}
+		if _, ok := p.Get("missing"); ok {
+			t.Errorf("%s: Get of missing key succeeded", tt.name)
+		}
+		if v, ok := p.Remove("5"); !ok || v.String() != "v5" {
+			t.Errorf("%s: Remove(5) = %q, %v; want v5, true", tt.name, v, ok)
+		}
+		if _, ok := p.Remove("5"); ok {
+			t.Errorf("%s: second Remove(5) succeeded", tt.name)
+		}
+		if n := p.Len(); n != 9 {
+			t.Errorf("%s: Len = %d; want 9", tt.name, n)
+		}
+		seen := make(map[string]bool)
+		for {
+			key, _, ok := p.Evict()
+			if !ok {
+				break
+			}
+			if seen[key] {
+				t.Errorf("%s: %q evicted twice", tt.name, key)
+			}
+			seen[key] = true
+		}
+		if len(seen) != 9 || p.Len() != 0 {
+			t.Errorf("%s: evicted %d keys, %d left; want 9, 0", tt.name, len(seen), p.Len())
+		}
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	p := NewLRU()
+	p.Add("a", ByteView{})
+	p.Add("b", ByteView{})
+	p.Add("c", ByteView{})
+	p.Get("a")
+	for _, want := range []string{"b", "c", "a"} {
+		if key, _, _ := p.Evict(); key != want {
+			t.Errorf("Evict = %q; want %q", key, want)
+		}
+	}
+}
+
+func TestLFUEvictsLeastFrequentlyUsed(t *testing.T) {
+	p := NewLFU()
+	p.Add("a", ByteView{})
+	p.Add("b", ByteView{})
+	p.Add("c", ByteView{})
+	p.Get("a")
+	p.Get("a")
+	p.Get("c")
+	for _, want := range []string{"b", "c", "a"} {
+		if key, _, _ := p.Evict(); key != want {
+			t.Errorf("Evict = %q; want %q", key, want)
+		}
+	}
+}
+
+func TestCLOCKGivesSecondChance(t *testing.T) {
+	p := NewCLOCK()
+	p.Add("a", ByteView{})
+	p.Add("b", ByteView{})
+	p.Add("c", ByteView{})
+	p.Get("a")
+	p.Get("c")
+	// The hand clears a's bit, evicts b, then clears c's bit and
+	// comes back around to a.
+	for _, want := range []string{"b", "a", "c"} {
+		if key, _, _ := p.Evict(); key != want {
+			t.Errorf("Evict = %q; want %q", key, want)
+		}
+	}
+}
+
+// TestScanResistance checks that the frequency-aware policies keep a
+// working set in use while a scan goes over many one-off keys.
+func TestScanResistance(t *testing.T) {
+	const capacity = 100
+	for _, tt := range evictionPolicies {
+		p := tt.new()
+		access := func(key string) {
+			if _, ok := p.Get(key); ok {
+				return
+			}
+			p.Add(key, ByteView{})
+			for p.Len() > capacity {
+				p.Evict()
+			}
+		}
+		for round := 0; round < 5; round++ {
+			for i := 0; i < capacity/2; i++ {
+				access(fmt.Sprintf("hot-%d", i))
+			}
+		}
+		for i := 0; i < 10*capacity; i++ {
+			access(fmt.Sprintf("scan-%d", i))
+		}
+		kept := 0
+		for i := 0; i < capacity/2; i++ {
+			if _, ok := p.Get(fmt.Sprintf("hot-%d", i)); ok {
+				kept++
+			}
+		}
+		if !tt.scanResistant {
+			if kept != 0 {
+				t.Errorf("%s kept %d hot keys through a scan; want 0", tt.name, kept)
+			}
+			continue
+		}
+		if kept < capacity/2*9/10 {
+			t.Errorf("%s kept %d of %d hot keys through a scan", tt.name, kept, capacity/2)
+		}
+	}
+}
+
+func TestGroupEvictionPolicy(t *testing.T) {
+	for _, tt := range evictionPolicies {
+		g := DefaultRegistry.newGroup("TestGroupEvictionPolicy-"+tt.name, 100, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+			return dest.SetString("0123456789")
+		}), NoPeers{}, &GroupOptions{NewEvictionPolicy: tt.new})
+		for i := 0; i < 20; i++ {
+			var s string
+			if err := g.Get(dummyCtx, fmt.Sprintf("k%02d", i), StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		st := g.CacheStats(MainCache)
+		// Each entry takes 13 bytes, so 7 fit in 100.
+		if st.Items != 7 || st.Bytes != 7*13 || st.Evictions != 13 {
+			t.Errorf("%s: stats = %+v; want 7 items, %d bytes, 13 evictions", tt.name, st, 7*13)
+		}
+		g.Close()
+	}
+}
+
+func TestShardedCache(t *testing.T) {
+	const cacheBytes = 10000
+	g := DefaultRegistry.newGroup("TestShardedCache-group", cacheBytes, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("0123456789")
+	}), NoPeers{}, &GroupOptions{
+		CacheShards:       8,
+		NewEvictionPolicy: NewCLOCK,
+	})
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				// Each key is read twice in a row, to hit.
+				key := fmt.Sprintf("k%04d", (i*7+w)%1500)
+				for j := 0; j < 2; j++ {
+					var s string
+					if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	st := g.CacheStats(MainCache)
+	if st.Bytes > cacheBytes {
+		t.Errorf("cache holds %d bytes; want at most %d", st.Bytes, cacheBytes)
+	}
+	if st.Items*15 != st.Bytes {
+		t.Errorf("cache holds %d items in %d bytes; want 15 bytes each", st.Items, st.Bytes)
+	}
+	if st.Gets < 8*2000 || st.Hits == 0 || st.Evictions == 0 {
+		t.Errorf("stats = %+v; want at least %d gets, some hits and evictions", st, 8*2000)
+	}
+	used := 0
+	for _, s := range g.mainCache.shards {
+		if s.items() > 0 {
+			used++
+		}
+	}
+	if used != 8 {
+		t.Errorf("%d of 8 shards hold keys", used)
+	}
+}
+
+// BenchmarkParallelHits measures concurrent cache hits of distinct
+// keys, with and without sharding.
+func BenchmarkParallelHits(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			g := DefaultRegistry.newGroup(fmt.Sprintf("BenchmarkParallelHits-%d", shards), 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+				return dest.SetString("value")
+			}), NoPeers{}, &GroupOptions{
+				CacheShards:       shards,
+				NewEvictionPolicy: NewCLOCK,
+			})
+			defer DefaultRegistry.DeregisterGroup(g.Name())
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key-%d", i)
+				var s string
+				if err := g.Get(dummyCtx, keys[i], StringSink(&s)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			var next int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(atomic.AddInt64(&next, 1) * 97)
+				var s string
+				for pb.Next() {
+					g.Get(dummyCtx, keys[i%len(keys)], StringSink(&s))
+					i++
+				}
+			})
+		})
+	}
+}
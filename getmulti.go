@@ -0,0 +1,270 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "github.com/golang/groupcache/groupcachepb"
+	"github.com/golang/protobuf/proto"
+)
+
+// A MultiError is returned by GetMulti when some of the keys failed
+// to load. It holds one error per key, nil for the keys that loaded.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	var first error
+	n := 0
+	for _, err := range m {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			n++
+		}
+	}
+	if n == 1 {
+		return first.Error()
+	}
+	return fmt.Sprintf("groupcache: %d of %d keys failed; first error: %v", n, len(m), first)
+}
+
+// GetMulti is like calling Get for each key with the Sink at the
+// same index of dests, but it asks each peer that is a PeerMultiGetter
+// for all of the missing keys it owns in a single request. Keys owned by this process are
+// loaded locally and concurrently, deduplicated with other loads of
+// the same key. Keys a peer fails to answer are asked of the owner's
+// fallbacks, if any, then loaded locally, as Get does.
+//
+// If some keys fail to load, GetMulti returns a MultiError; the
+// sinks of the other keys are still populated.
+func (g *Group) GetMulti(ctx context.Context, keys []string, dests []Sink) error {
+	ctx, span := g.startSpan(ctx, SpanLookup, "")
+	span.SetAttribute("groupcache.keys", strconv.Itoa(len(keys)))
+	err := g.getMulti(ctx, keys, dests)
+	span.End(err)
+	return err
+}
+
+// getMulti implements GetMulti within its lookup span.
+func (g *Group) getMulti(ctx context.Context, keys []string, dests []Sink) error {
+	if len(keys) != len(dests) {
+		return errors.New("groupcache: GetMulti needs one dest Sink per key")
+	}
+	g.peersOnce.Do(g.initPeers)
+	values := make([]ByteView, len(keys))
+	errs := make(MultiError, len(keys))
+
+	// misses maps each key that must be loaded to its indexes in keys.
+	misses := make(map[string][]int)
+	for i, key := range keys {
+		g.Stats.Gets.Add(1)
+		g.rates.add(key, nowFunc())
+		if dests[i] == nil {
+			errs[i] = errors.New("groupcache: nil dest Sink")
+			continue
+		}
+		if idx, ok := misses[key]; ok {
+			misses[key] = append(idx, i)
+			continue
+		}
+		value, which, cacheHit := g.lookupCacheTier(key)
+		g.observeLookup(key, which, cacheHit)
+		if cacheHit {
+			g.Stats.CacheHits.Add(1)
+			if value.expired(nowFunc()) {
+				g.Stats.StaleHits.Add(1)
+				g.revalidate(key)
+			}
+			values[i] = value
+			continue
+		}
+		g.noteMiss(key)
+		if err, ok := g.lookupError(key); ok {
+			errs[i] = err
+			continue
+		}
+		misses[key] = []int{i}
+	}
+
+	// Each key is only set by one goroutine, and its indexes are
+	// distinct from every other key's.
+	set := func(key string, value ByteView, err error) {
+		for _, i := range misses[key] {
+			values[i], errs[i] = value, err
+		}
+	}
+	// A load that panics in one of the goroutines below panics in
+	// the caller once every load is done.
+	var (
+		panicOnce  sync.Once
+		panicValue interface{}
+	)
+	loadOne := func(key string, path loadPath) {
+		defer func() {
+			if r := recover(); r != nil {
+				panicOnce.Do(func() { panicValue = r })
+			}
+		}()
+		var value ByteView
+		value, _, err := g.load(ctx, key, ByteViewSink(&value), path)
+		set(key, value, err)
+	}
+
+	var wg sync.WaitGroup
+	byPeer := make(map[ProtoGetter][]string)
+	for key := range misses {
+		g.Stats.Loads.Add(1)
+		if peer, ok := g.peers.PickPeer(key); ok && g.batches(peer) {
+			byPeer[peer] = append(byPeer[peer], key)
+			continue
+		}
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			loadOne(key, viaOwner)
+		}(key)
+	}
+	for peer, keys := range byPeer {
+		wg.Add(1)
+		go func(peer ProtoGetter, keys []string) {
+			defer wg.Done()
+			for _, key := range g.getMultiFromPeer(ctx, peer, keys, set) {
+				wg.Add(1)
+				go func(key string) {
+					defer wg.Done()
+					loadOne(key, viaFallbacks)
+				}(key)
+			}
+		}(peer, keys)
+	}
+	wg.Wait()
+	if panicValue != nil {
+		panic(panicValue)
+	}
+
+	failed := false
+	for i, err := range errs {
+		if err != nil {
+			failed = true
+			continue
+		}
+		if err := setSinkView(dests[i], values[i]); err != nil {
+			errs[i] = err
+			failed = true
+		}
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+// batches reports whether GetMulti may ask peer for its keys in one
+// request. Interceptors see one key at a time, so they need keys to
+// be sent one by one.
+func (g *Group) batches(peer ProtoGetter) bool {
+	_, ok := peer.(PeerMultiGetter)
+	return ok && len(g.opts.PeerInterceptors) == 0
+}
+
+// getMultiFromPeer asks peer, a PeerMultiGetter, for keys in one
+// request and passes each answer to set. It returns the keys that the
+// peer failed to answer and that should be loaded locally instead.
+func (g *Group) getMultiFromPeer(ctx context.Context, peer ProtoGetter, keys []string, set func(key string, value ByteView, err error)) (retry []string) {
+	req := &pb.GetMultiRequest{
+		Group: &g.name,
+		Keys:  keys,
+	}
+	res := &pb.GetMultiResponse{}
+	ctx, span := g.startSpan(ctx, SpanPeerFetch, "")
+	span.SetAttribute("groupcache.peer", peerName(peer))
+	span.SetAttribute("groupcache.keys", strconv.Itoa(len(keys)))
+	g.observe(Event{Type: PeerFetchStart, Peer: peerName(peer), Batch: len(keys)})
+	start := time.Now()
+	err := peer.(PeerMultiGetter).GetMulti(ctx, req, res)
+	if err == nil && len(res.Responses) != len(keys) {
+		err = fmt.Errorf("groupcache: peer answered %d of %d keys", len(res.Responses), len(keys))
+	}
+	latency := time.Since(start)
+	g.peerLatency.observe(latency)
+	g.observe(Event{Type: PeerFetchDone, Peer: peerName(peer), Batch: len(keys), Err: err, Latency: latency})
+	span.End(err)
+	if err != nil {
+		g.Stats.PeerErrors.Add(int64(len(keys)))
+		g.peerErrors.add(peer, "", err)
+		return keys
+	}
+	for i, key := range keys {
+		value, err := g.peerResponse(key, res.Responses[i])
+		if err == nil {
+			g.Stats.PeerLoads.Add(1)
+			set(key, value, nil)
+			continue
+		}
+		if _, ok := err.(remoteGetterError); ok {
+			set(key, ByteView{}, err)
+			continue
+		}
+		g.Stats.PeerErrors.Add(1)
+		g.peerErrors.add(peer, key, err)
+		retry = append(retry, key)
+	}
+	return retry
+}
+
+// serveGetMulti answers a batch request received from a peer. Errors
+// are returned in the responses of their keys: batch requests only
+// come from peers that know GetResponse.error.
+func (g *Group) serveGetMulti(ctx context.Context, keys []string) *pb.GetMultiResponse {
+	values := make([]ByteView, len(keys))
+	dests := make([]Sink, len(keys))
+	for i := range keys {
+		dests[i] = ByteViewSink(&values[i])
+	}
+	var errs MultiError
+	if err := g.GetMulti(ctx, keys, dests); err != nil {
+		errs = err.(MultiError)
+	}
+	res := &pb.GetMultiResponse{Responses: make([]*pb.GetResponse, len(keys))}
+	for i, key := range keys {
+		if errs != nil && errs[i] != nil {
+			r := &pb.GetResponse{Error: proto.String(errs[i].Error())}
+			if err, expire, ok := g.cachedError(key); ok {
+				r.Error = proto.String(err.Error())
+				r.Expire = proto.Int64(expire.UnixNano())
+			}
+			res.Responses[i] = r
+			continue
+		}
+		r := &pb.GetResponse{
+			Value:     values[i].ByteSlice(),
+			MinuteQps: proto.Float64(g.keyQPS(key)),
+		}
+		if e := values[i].Expire(); !e.IsZero() {
+			r.Expire = proto.Int64(e.UnixNano())
+		}
+		res.Responses[i] = r
+	}
+	return res
+}
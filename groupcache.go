@@ -27,14 +27,14 @@ package groupcache
 import (
 	"context"
 	"errors"
-	"math/rand"
+	"fmt"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	pb "github.com/golang/groupcache/groupcachepb"
 	"github.com/golang/groupcache/lru"
-	"github.com/golang/groupcache/singleflight"
 )
 
 // A Getter loads data for a key.
@@ -43,8 +43,10 @@ type Getter interface {
 	//
 	// The returned data must be unversioned. That is, key must
 	// uniquely describe the loaded data, without an implicit
-	// current time, and without relying on cache expiration
-	// mechanisms.
+	// current time, unless the Getter bounds the data's lifetime
+	// by calling dest.SetExpire. Expiring values are dropped from
+	// every cache, including peers' hot caches, once their
+	// expiration time has passed.
 	Get(ctx context.Context, key string, dest Sink) error
 }
 
@@ -55,21 +57,13 @@ func (f GetterFunc) Get(ctx context.Context, key string, dest Sink) error {
 	return f(ctx, key, dest)
 }
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-
-	initPeerServerOnce sync.Once
-	initPeerServer     func()
-)
+// nowFunc returns the current time. Tests may replace it.
+var nowFunc = time.Now
 
 // GetGroup returns the named group previously created with NewGroup, or
 // nil if there's no such group.
 func GetGroup(name string) *Group {
-	mu.RLock()
-	g := groups[name]
-	mu.RUnlock()
-	return g
+	return DefaultRegistry.GetGroup(name)
 }
 
 // NewGroup creates a coordinated group-aware Getter from a Getter.
@@ -81,67 +75,169 @@ func GetGroup(name string) *Group {
 // completes.
 //
 // The group name must be unique for each getter.
+//
+// NewGroup registers the group in DefaultRegistry.
 func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
-	return newGroup(name, cacheBytes, getter, nil)
+	return DefaultRegistry.NewGroup(name, cacheBytes, getter)
+}
+
+// NewGroupOpts is like NewGroup, but configures the group with the
+// given options. A nil o is equivalent to an empty GroupOptions.
+func NewGroupOpts(name string, cacheBytes int64, getter Getter, o *GroupOptions) *Group {
+	return DefaultRegistry.NewGroupOpts(name, cacheBytes, getter, o)
 }
 
 // If peers is nil, the peerPicker is called via a sync.Once to initialize it.
 func newGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker) *Group {
-	if getter == nil {
-		panic("nil Getter")
-	}
-	mu.Lock()
-	defer mu.Unlock()
-	initPeerServerOnce.Do(callInitPeerServer)
-	if _, dup := groups[name]; dup {
-		panic("duplicate registration of group " + name)
-	}
-	g := &Group{
-		name:       name,
-		getter:     getter,
-		peers:      peers,
-		cacheBytes: cacheBytes,
-		loadGroup:  &singleflight.Group{},
-	}
-	if fn := newGroupHook; fn != nil {
-		fn(g)
-	}
-	groups[name] = g
-	return g
+	return DefaultRegistry.newGroup(name, cacheBytes, getter, peers, nil)
+}
+
+// GroupOptions are the configurations of a Group.
+type GroupOptions struct {
+	// ErrorTTL, if positive, enables negative caching: errors
+	// returned by the Getter are remembered for ErrorTTL, and Gets
+	// of the key fail with the same error without loading it
+	// again. Peers asking this process for the key get the error
+	// too, and remember it until it expires here. Each consecutive
+	// failure of a key doubles the time it is remembered, up to
+	// MaxErrorTTL; a successful load resets it.
+	ErrorTTL time.Duration
+
+	// MaxErrorTTL bounds the backoff of ErrorTTL.
+	// If zero, it defaults to ErrorTTL, so errors don't back off.
+	MaxErrorTTL time.Duration
+
+	// CacheError reports whether err, returned by the Getter for
+	// key, should be remembered. If nil, all errors are, except
+	// those caused by the context being canceled or timing out.
+	CacheError func(key string, err error) bool
+
+	// StaleWhileRevalidate, if positive, is how long past its
+	// expiration a cached value may still be served. Such a stale
+	// hit returns the cached value right away and starts a single
+	// background reload of the key. If the reload fails, the stale
+	// value keeps being served until the window closes.
+	StaleWhileRevalidate time.Duration
+
+	// HotCacheQPS is the request rate, in queries per second, from
+	// which a key owned by another peer counts as hot and gets
+	// mirrored into the hot cache. A key is hot if this process
+	// alone requests it at HotCacheQPS, or if the key's owner
+	// reports at least HotCacheQPS for it and this process
+	// requested it more than once in the last minute. Zero means a
+	// default of 1; a negative value disables mirroring.
+	HotCacheQPS float64
+
+	// NewEvictionPolicy, if non-nil, creates the eviction policy of
+	// each of the group's caches. It is called once for each
+	// shard of the main and hot caches. If nil, NewLRU is used.
+	NewEvictionPolicy func() EvictionPolicy
+
+	// HotCacheRatio is the share of the group's cacheBytes that
+	// the hot cache may use, between 0 and 1; the main cache gets
+	// the rest. If zero, it defaults to 1/9, so that the hot cache
+	// can grow to an eighth of the main cache. With
+	// AdaptiveHotCache, it is only the initial share.
+	HotCacheRatio float64
+
+	// AdaptiveHotCache makes the share of the hot cache adapt to
+	// the workload. Both caches remember the keys they recently
+	// evicted, and each miss on such a key grows the share of the
+	// cache that evicted it. The share of the hot cache stays
+	// between 1/16 and 1/2, or HotCacheRatio if outside of them.
+	// The current shares are reported by CacheStats as TargetBytes.
+	AdaptiveHotCache bool
+
+	// CacheShards is the number of shards each of the group's
+	// caches is split into, by key hash. Each shard has its own
+	// lock, so that concurrent lookups of different keys don't
+	// contend; the shards share the group's byte budget. The
+	// request rates of keys are tracked in as many shards. Zero
+	// means one. See also NewCLOCK, whose hits take no write lock.
+	CacheShards int
+
+	// DiskDir, if non-empty, enables a second cache tier on local
+	// disk, in files under DiskDir. Entries evicted from the main
+	// cache are written there, up to DiskBytes of files, and
+	// lookups missing both in-memory caches check it before asking
+	// peers or the Getter. Each file carries a checksum; a corrupt
+	// file is deleted and treated as a miss. Files are written in
+	// the background; evictions arriving while the writer is too
+	// far behind are not written and counted in Stats.DiskDrops.
+	//
+	// WARNING: the group owns DiskDir. On first use it deletes every
+	// *.gcache file in DiskDir, whoever left it there, since the
+	// index of the tier is only kept in memory. Give each group a
+	// directory of its own.
+	DiskDir string
+
+	// DiskBytes is the budget of the disk tier. The tier is
+	// disabled unless both DiskDir and DiskBytes are set.
+	DiskBytes int64
+
+	// Observer, if non-nil, is notified of the group's cache hits
+	// and misses, loads, requests to peers and evictions.
+	Observer Observer
+
+	// Tracer, if non-nil, traces the group's lookups, requests to
+	// peers and loads from the Getter. See the Span constants.
+	Tracer Tracer
+
+	// GetterInterceptors wrap the group's loads from its Getter, the
+	// first being the outermost.
+	GetterInterceptors []GetterInterceptor
+
+	// PeerInterceptors wrap the group's Get requests to peers, the
+	// first being the outermost. Since they see one key at a time,
+	// GetMulti sends its keys to peers one by one when they are set.
+	PeerInterceptors []PeerInterceptor
+
+	// PeerFallbacks is how many peers following a key's owner are
+	// asked for the key, in turn, when the owner can't be reached.
+	// A peer asked this way loads the key itself, so that during an
+	// owner's outage the key is still loaded about once for the whole
+	// cluster instead of once per requesting process. Only the
+	// PeerPickers that implement FallbackPicker have fallbacks. If
+	// zero, a key whose owner fails is loaded locally.
+	PeerFallbacks int
 }
 
-// newGroupHook, if non-nil, is called right after a new group is created.
-var newGroupHook func(*Group)
+// DeregisterGroup removes the named group from DefaultRegistry.
+func DeregisterGroup(name string) {
+	DefaultRegistry.DeregisterGroup(name)
+}
 
 // RegisterNewGroupHook registers a hook that is run each time
-// a group is created.
+// a group is created in DefaultRegistry.
 func RegisterNewGroupHook(fn func(*Group)) {
-	if newGroupHook != nil {
-		panic("RegisterNewGroupHook called more than once")
-	}
-	newGroupHook = fn
+	DefaultRegistry.RegisterNewGroupHook(fn)
 }
 
 // RegisterServerStart registers a hook that is run when the first
-// group is created.
+// group is created in DefaultRegistry.
 func RegisterServerStart(fn func()) {
-	if initPeerServer != nil {
-		panic("RegisterServerStart called more than once")
-	}
-	initPeerServer = fn
-}
-
-func callInitPeerServer() {
-	if initPeerServer != nil {
-		initPeerServer()
-	}
+	DefaultRegistry.RegisterServerStart(fn)
 }
 
 // A Group is a cache namespace and associated data loaded spread over
 // a group of 1 or more machines.
 type Group struct {
+	// Stats are statistics on the group.
+	//
+	// Stats and the latency histograms hold 64-bit atomics, and
+	// are kept first so that they are 8-byte aligned on 32-bit
+	// platforms.
+	Stats Stats
+
+	// localLatency and peerLatency are the latencies of loads from
+	// the getter and from peers.
+	localLatency latencyHistogram
+	peerLatency  latencyHistogram
+
 	name       string
 	getter     Getter
+	registry   *Registry
+	opts       GroupOptions
 	peersOnce  sync.Once
 	peers      PeerPicker
 	cacheBytes int64 // limit for sum of mainCache and hotCache size
@@ -162,15 +258,34 @@ type Group struct {
 	// of key/value pairs that can be stored globally.
 	hotCache cache
 
+	// disk is the disk tier under mainCache, or nil.
+	// See GroupOptions.DiskDir.
+	disk *diskCache
+
+	// split divides cacheBytes between mainCache and hotCache.
+	split cacheSplit
+
+	// rates tracks the recent request rate of keys. The owner of a
+	// key reports it to peers, which use it and their own rate to
+	// decide what to keep in hotCache.
+	rates rateTracker
+
+	// peerErrors keeps the most recent failures of peers.
+	peerErrors peerErrorLog
+
+	// errCache remembers recent load errors, for negative
+	// caching. See GroupOptions.ErrorTTL.
+	errCache errorCache
+
+	// refreshing holds the keys being reloaded in the background
+	// after a stale hit.
+	refreshMu  sync.Mutex
+	refreshing map[string]bool
+
 	// loadGroup ensures that each key is only fetched once
 	// (either locally or remotely), regardless of the number of
 	// concurrent callers.
 	loadGroup flightGroup
-
-	_ int32 // force Stats to be 8-byte aligned on 32-bit platforms
-
-	// Stats are statistics on the group.
-	Stats Stats
 }
 
 // flightGroup is defined as an interface which flightgroup.Group
@@ -178,20 +293,29 @@ type Group struct {
 // implementation.
 type flightGroup interface {
 	// Done is called when Do is done.
-	Do(key string, fn func() (interface{}, error)) (interface{}, error)
+	Do(key string, fn func() (ByteView, error)) (v ByteView, err error, shared bool)
+	DoContext(ctx context.Context, key string, fn func(context.Context) (ByteView, error)) (v ByteView, err error, shared bool)
 }
 
-// Stats are per-group statistics.
+// Stats are per-group statistics. Its counters are accessed with
+// 64-bit atomics, so a struct holding Stats must keep it 8-byte
+// aligned on 32-bit platforms, for instance as its first field.
 type Stats struct {
 	Gets           AtomicInt // any Get request, including from peers
 	CacheHits      AtomicInt // either cache was good
 	PeerLoads      AtomicInt // either remote load or remote cache hit (not an error)
 	PeerErrors     AtomicInt
 	Loads          AtomicInt // (gets - cacheHits)
-	LoadsDeduped   AtomicInt // after singleflight
+	LoadsDeduped   AtomicInt // loads answered by a concurrent load of the same key
 	LocalLoads     AtomicInt // total good local loads
 	LocalLoadErrs  AtomicInt // total bad local loads
 	ServerRequests AtomicInt // gets that came over the network from peers
+	ErrorHits      AtomicInt // gets answered with a cached load error
+	StaleHits      AtomicInt // cache hits served stale while revalidating
+	DiskErrors     AtomicInt // disk tier reads and writes that failed, including corrupt files
+	DiskDrops      AtomicInt // main cache evictions not written to disk because the writer was behind
+	LoadPanics     AtomicInt // loads whose Getter or peer request panicked
+	FallbackLoads  AtomicInt // peer loads answered by a fallback of the key's owner
 }
 
 // Name returns the name of the group.
@@ -201,29 +325,66 @@ func (g *Group) Name() string {
 
 func (g *Group) initPeers() {
 	if g.peers == nil {
-		g.peers = getPeers(g.name)
+		g.peers = g.registry.getPeers(g.name)
 	}
 }
 
+// Get populates dest with the value of key, from a cache, the key's
+// owner or the Getter.
+//
+// If the load of key panics, the panic propagates to the caller that
+// started the load as a *singleflight.PanicError, and the callers
+// waiting for the same load return that error.
 func (g *Group) Get(ctx context.Context, key string, dest Sink) error {
+	ctx, span := g.startSpan(ctx, SpanLookup, key)
+	err := g.get(ctx, span, key, dest, viaOwner)
+	span.End(err)
+	return err
+}
+
+// getFallback is like Get, but for a peer that couldn't reach the
+// owner of key: the key is loaded without asking any peer.
+func (g *Group) getFallback(ctx context.Context, key string, dest Sink) error {
+	ctx, span := g.startSpan(ctx, SpanLookup, key)
+	span.SetAttribute("groupcache.fallback", "true")
+	err := g.get(ctx, span, key, dest, localOnly)
+	span.End(err)
+	return err
+}
+
+// get implements Get within its lookup span, loading a missing key
+// through path.
+func (g *Group) get(ctx context.Context, span Span, key string, dest Sink, path loadPath) error {
 	g.peersOnce.Do(g.initPeers)
 	g.Stats.Gets.Add(1)
+	g.rates.add(key, nowFunc())
 	if dest == nil {
 		return errors.New("groupcache: nil dest Sink")
 	}
-	value, cacheHit := g.lookupCache(key)
+	value, which, cacheHit := g.lookupCacheTier(key)
+	g.observeLookup(key, which, cacheHit)
 
 	if cacheHit {
+		span.SetAttribute("groupcache.cache", which.String())
 		g.Stats.CacheHits.Add(1)
+		if value.expired(nowFunc()) {
+			g.Stats.StaleHits.Add(1)
+			g.revalidate(key)
+		}
 		return setSinkView(dest, value)
 	}
+	g.noteMiss(key)
+	if err, ok := g.lookupError(key); ok {
+		return err
+	}
 
 	// Optimization to avoid double unmarshalling or copying: keep
 	// track of whether the dest was already populated. One caller
 	// (if local) will set this; the losers will not. The common
 	// case will likely be one caller.
 	destPopulated := false
-	value, destPopulated, err := g.load(ctx, key, dest)
+	g.Stats.Loads.Add(1)
+	value, destPopulated, err := g.load(ctx, key, dest, path)
 	if err != nil {
 		return err
 	}
@@ -233,10 +394,29 @@ func (g *Group) Get(ctx context.Context, key string, dest Sink) error {
 	return setSinkView(dest, value)
 }
 
+// A loadPath tells which peers a load asks for a key before
+// invoking the getter.
+type loadPath int
+
+const (
+	viaOwner     loadPath = iota // the key's owner, then its fallbacks
+	viaFallbacks                 // the owner's fallbacks only, as the owner failed
+	localOnly                    // no peer, as this process is a fallback
+)
+
 // load loads key either by invoking the getter locally or by sending it to another machine.
-func (g *Group) load(ctx context.Context, key string, dest Sink) (value ByteView, destPopulated bool, err error) {
-	g.Stats.Loads.Add(1)
-	viewi, err := g.loadGroup.Do(key, func() (interface{}, error) {
+// The peers asked first depend on path.
+//
+// The load runs with a context detached from ctx, so that it goes on
+// while any caller still waits for it; the caller returns early if ctx
+// is done first. As it may then outlive the caller, the load only
+// populates dest if ctx can't be done.
+func (g *Group) load(ctx context.Context, key string, dest Sink, path loadPath) (value ByteView, destPopulated bool, err error) {
+	loadDest := dest
+	if ctx.Done() != nil {
+		loadDest = nil
+	}
+	value, err, shared := g.loadGroup.DoContext(ctx, key, g.countPanics(func(ctx context.Context) (ByteView, error) {
 		// Check the cache again because singleflight can only dedup calls
 		// that overlap concurrently.  It's possible for 2 concurrent
 		// requests to miss the cache, resulting in 2 load() calls.  An
@@ -258,39 +438,170 @@ func (g *Group) load(ctx context.Context, key string, dest Sink) (value ByteView
 		// 1: fn()
 		// 2: loadGroup.Do("key", fn)
 		// 2: fn()
-		if value, cacheHit := g.lookupCache(key); cacheHit {
+		if value, cacheHit := g.lookupCache(key); cacheHit && !value.expired(nowFunc()) {
 			g.Stats.CacheHits.Add(1)
 			return value, nil
 		}
-		g.Stats.LoadsDeduped.Add(1)
-		var value ByteView
-		var err error
-		if peer, ok := g.peers.PickPeer(key); ok {
-			value, err = g.getFromPeer(ctx, peer, key)
-			if err == nil {
-				g.Stats.PeerLoads.Add(1)
-				return value, nil
-			}
-			g.Stats.PeerErrors.Add(1)
-			// TODO(bradfitz): log the peer's error? keep
-			// log of the past few for /groupcachez?  It's
-			// probably boring (normal task movement), so not
-			// worth logging I imagine.
+		if err, ok := g.lookupError(key); ok {
+			return ByteView{}, err
+		}
+		value, fetched, err := g.fetchFromPeers(ctx, key, path)
+		if fetched {
+			return value, err
+		}
+		sink := loadDest
+		if sink == nil {
+			sink = ByteViewSink(&value)
 		}
-		value, err = g.getLocally(ctx, key, dest)
+		value, err = g.loadLocally(ctx, key, sink)
 		if err != nil {
 			g.Stats.LocalLoadErrs.Add(1)
-			return nil, err
+			g.rememberError(key, err)
+			return ByteView{}, err
 		}
 		g.Stats.LocalLoads.Add(1)
-		destPopulated = true // only one caller of load gets this return value
+		g.errCache.remove(key)
+		if loadDest != nil {
+			// Only written when the caller waits for the load.
+			destPopulated = true // only one caller of load gets this return value
+		}
 		g.populateCache(key, value, &g.mainCache)
 		return value, nil
-	})
-	if err == nil {
-		value = viewi.(ByteView)
+	}))
+	if shared {
+		g.Stats.LoadsDeduped.Add(1)
+		g.observe(Event{Type: LoadDeduped, Key: key})
 	}
-	return
+	return value, destPopulated, err
+}
+
+// countPanics returns fn, counting its panics in g.Stats.
+func (g *Group) countPanics(fn func(context.Context) (ByteView, error)) func(context.Context) (ByteView, error) {
+	return func(ctx context.Context) (ByteView, error) {
+		panicked := true
+		defer func() {
+			if panicked {
+				g.Stats.LoadPanics.Add(1)
+			}
+		}()
+		v, err := fn(ctx)
+		panicked = false
+		return v, err
+	}
+}
+
+// fetchFromPeers asks the peers on path for key in turn, until one
+// of them answers. It returns fetched as true with the answer, a value
+// or the error of the peer's Getter; otherwise key is to be loaded
+// locally.
+func (g *Group) fetchFromPeers(ctx context.Context, key string, path loadPath) (value ByteView, fetched bool, err error) {
+	if path == localOnly {
+		return ByteView{}, false, nil
+	}
+	owner, ok := g.peers.PickPeer(key)
+	if !ok {
+		return ByteView{}, false, nil
+	}
+	var peers []ProtoGetter
+	if path == viaOwner {
+		peers = append(peers, owner)
+	}
+	if fp, ok := g.peers.(FallbackPicker); ok && g.opts.PeerFallbacks > 0 {
+		peers = append(peers, fp.PickFallbacks(key, g.opts.PeerFallbacks)...)
+	}
+	for i, peer := range peers {
+		fallback := path == viaFallbacks || i > 0
+		value, err = g.fetchFromPeer(ctx, peer, key, fallback)
+		if err == nil {
+			g.Stats.PeerLoads.Add(1)
+			if fallback {
+				g.Stats.FallbackLoads.Add(1)
+			}
+			return value, true, nil
+		}
+		if _, ok := err.(remoteGetterError); ok {
+			return ByteView{}, true, err
+		}
+		g.Stats.PeerErrors.Add(1)
+		g.peerErrors.add(peer, key, err)
+		if ctx.Err() != nil {
+			// Nobody waits for the answer of the next peer.
+			break
+		}
+	}
+	return ByteView{}, false, nil
+}
+
+// fetchFromPeer gets key from peer within a span, reporting the
+// request to g's observer. If fallback is true, peer is asked to load
+// key in place of its owner.
+func (g *Group) fetchFromPeer(ctx context.Context, peer ProtoGetter, key string, fallback bool) (ByteView, error) {
+	ctx, span := g.startSpan(ctx, SpanPeerFetch, key)
+	span.SetAttribute("groupcache.peer", peerName(peer))
+	if fallback {
+		span.SetAttribute("groupcache.fallback", "true")
+	}
+	g.observe(Event{Type: PeerFetchStart, Key: key, Peer: peerName(peer)})
+	start := time.Now()
+	value, err := g.getFromPeer(ctx, peer, key, fallback)
+	latency := time.Since(start)
+	g.peerLatency.observe(latency)
+	g.observe(Event{Type: PeerFetchDone, Key: key, Peer: peerName(peer), Err: err, Latency: latency})
+	span.End(err)
+	return value, err
+}
+
+// loadLocally gets key from the getter within a span, reporting the
+// call to g's observer.
+func (g *Group) loadLocally(ctx context.Context, key string, dest Sink) (ByteView, error) {
+	ctx, span := g.startSpan(ctx, SpanLocalLoad, key)
+	g.observe(Event{Type: LocalLoadStart, Key: key})
+	start := time.Now()
+	value, err := g.getLocally(ctx, key, dest)
+	latency := time.Since(start)
+	g.localLatency.observe(latency)
+	g.observe(Event{Type: LocalLoadDone, Key: key, Err: err, Latency: latency})
+	span.End(err)
+	return value, err
+}
+
+// revalidate starts reloading key in the background after a stale
+// hit, unless a reload of key is already running. The reload goes
+// through loadGroup, so it is also shared with foreground loads.
+func (g *Group) revalidate(key string) {
+	g.refreshMu.Lock()
+	if g.refreshing[key] {
+		g.refreshMu.Unlock()
+		return
+	}
+	if g.refreshing == nil {
+		g.refreshing = make(map[string]bool)
+	}
+	g.refreshing[key] = true
+	g.refreshMu.Unlock()
+
+	go func() {
+		defer func() {
+			g.refreshMu.Lock()
+			delete(g.refreshing, key)
+			g.refreshMu.Unlock()
+			// There's no caller to panic in; the panic is
+			// counted in Stats.LoadPanics.
+			recover()
+		}()
+		var value ByteView
+		g.Stats.Loads.Add(1)
+		value, _, err := g.load(context.Background(), key, ByteViewSink(&value), viaOwner)
+		if err != nil {
+			return
+		}
+		// A value fetched from the owner only replaces a mirrored
+		// copy if the hot cache admits it; make sure the stale copy
+		// doesn't outlive its refresh.
+		if g.cacheBytes > 0 && g.hotCache.has(key) {
+			g.populateCache(key, value, &g.hotCache)
+		}
+	}()
 }
 
 func (g *Group) getLocally(ctx context.Context, key string, dest Sink) (ByteView, error) {
@@ -301,43 +612,259 @@ func (g *Group) getLocally(ctx context.Context, key string, dest Sink) (ByteView
 	return dest.view()
 }
 
-func (g *Group) getFromPeer(ctx context.Context, peer ProtoGetter, key string) (ByteView, error) {
+func (g *Group) getFromPeer(ctx context.Context, peer ProtoGetter, key string, fallback bool) (ByteView, error) {
 	req := &pb.GetRequest{
 		Group: &g.name,
 		Key:   &key,
 	}
+	if fallback {
+		req.Fallback = &fallback
+	}
 	res := &pb.GetResponse{}
-	err := peer.Get(ctx, req, res)
+	err := g.peerGet(ctx, peer, req, res)
 	if err != nil {
 		return ByteView{}, err
 	}
+	return g.peerResponse(key, res)
+}
+
+// peerResponse returns the value or error that a peer answered for
+// key, mirroring the value into the hot cache if key is hot.
+func (g *Group) peerResponse(key string, res *pb.GetResponse) (ByteView, error) {
+	if res.Error != nil {
+		// The owner's Getter failed. Loading the key elsewhere
+		// would only repeat the failing load.
+		err := remoteGetterError(res.GetError())
+		if res.Expire != nil {
+			// The owner cached the failure. Remember it for as
+			// long as the owner does.
+			g.errCache.addUntil(key, err, time.Unix(0, res.GetExpire()))
+		}
+		return ByteView{}, err
+	}
 	value := ByteView{b: res.Value}
-	// TODO(bradfitz): use res.MinuteQps or something smart to
-	// conditionally populate hotCache.  For now just do it some
-	// percentage of the time.
-	if rand.Intn(10) == 0 {
+	if res.Expire != nil {
+		value.e = time.Unix(0, res.GetExpire())
+	}
+	if g.isHot(key, res.GetMinuteQps()) {
 		g.populateCache(key, value, &g.hotCache)
 	}
 	return value, nil
 }
 
+// Close deregisters g from its registry and drops all of g's cached
+// values. Peers asking for keys of g are answered as if g never
+// existed. g must not be used after Close.
+func (g *Group) Close() {
+	g.registry.deregister(g)
+	g.mainCache.clear()
+	g.hotCache.clear()
+	if g.disk != nil {
+		g.disk.close()
+	}
+}
+
+// Set stores value as key's value on the key's owner, without calling
+// the Getter. It is meant for callers that already have a freshly
+// computed value and want to seed the cluster with it. If the key is
+// owned by another peer and hotCache is true, the value is also
+// mirrored into this process's hot cache. The zero expire means the
+// value never expires.
+//
+// Set does not update copies of key already mirrored in other peers'
+// hot caches; call Remove first if those must not be served. HTTPPool
+// peers reject Set requests larger than 64 MB.
+func (g *Group) Set(ctx context.Context, key string, value []byte, expire time.Time, hotCache bool) error {
+	g.peersOnce.Do(g.initPeers)
+	view := ByteView{b: cloneBytes(value), e: expire}
+	g.errCache.remove(key)
+	owner, ok := g.peers.PickPeer(key)
+	if !ok {
+		g.populateCache(key, view, &g.mainCache)
+		return nil
+	}
+	req := &pb.SetRequest{
+		Group: &g.name,
+		Key:   &key,
+		Value: view.b,
+	}
+	if !expire.IsZero() {
+		e := expire.UnixNano()
+		req.Expire = &e
+	}
+	setter, ok := owner.(PeerSetter)
+	if !ok {
+		return ErrPeerUnsupported
+	}
+	if err := setter.Set(ctx, req, &pb.SetResponse{}); err != nil {
+		return err
+	}
+	if hotCache {
+		g.populateCache(key, view, &g.hotCache)
+	} else if g.cacheBytes > 0 {
+		g.hotCache.remove(key)
+	}
+	return nil
+}
+
+// serveSet handles a request from a peer to store value for key.
+func (g *Group) serveSet(key string, value ByteView) {
+	g.errCache.remove(key)
+	g.populateCache(key, value, &g.mainCache)
+}
+
+// Remove evicts key from this process's caches and asks the key's
+// owner to do the same. The owner then forwards the invalidation to
+// every other peer, so that copies mirrored in their hot caches are
+// dropped too. Peers are only reached if the PeerPicker implements
+// PeerLister, and if they implement PeerRemover; if the owner
+// doesn't, Remove asks the other peers itself.
+//
+// Remove does not interrupt loads of key that are already in flight;
+// their results may still be cached once they complete.
+func (g *Group) Remove(ctx context.Context, key string) error {
+	g.peersOnce.Do(g.initPeers)
+	g.localRemove(key)
+	if owner, ok := g.peers.PickPeer(key); ok {
+		if remover, ok := owner.(PeerRemover); ok {
+			req := &pb.RemoveRequest{
+				Group: &g.name,
+				Key:   &key,
+			}
+			err := remover.Remove(ctx, req, &pb.RemoveResponse{})
+			if !errors.Is(err, ErrPeerUnsupported) {
+				return err
+			}
+		}
+	}
+	return g.removeFromPeers(ctx, key)
+}
+
+// serveRemove handles a removal request received from a peer.
+func (g *Group) serveRemove(ctx context.Context, key string) error {
+	g.peersOnce.Do(g.initPeers)
+	g.localRemove(key)
+	if _, ok := g.peers.PickPeer(key); ok {
+		// We're not the owner, so this is the owner's fan-out.
+		// Never forward it: peers that disagree about who owns
+		// the key would otherwise bounce it between them.
+		return nil
+	}
+	return g.removeFromPeers(ctx, key)
+}
+
+func (g *Group) localRemove(key string) {
+	g.errCache.remove(key)
+	if g.cacheBytes <= 0 {
+		return
+	}
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	if g.disk != nil {
+		g.disk.remove(key)
+	}
+}
+
+// removeFromPeers asks every other peer that is a PeerRemover to
+// evict key, returning the first error encountered. Peers that turn
+// out not to support removal are skipped.
+func (g *Group) removeFromPeers(ctx context.Context, key string) error {
+	lister, ok := g.peers.(PeerLister)
+	if !ok {
+		return nil
+	}
+	var peers []PeerRemover
+	for _, peer := range lister.ListPeers() {
+		if remover, ok := peer.(PeerRemover); ok {
+			peers = append(peers, remover)
+		}
+	}
+	errc := make(chan error, len(peers))
+	for _, peer := range peers {
+		go func(peer PeerRemover) {
+			req := &pb.RemoveRequest{
+				Group: &g.name,
+				Key:   &key,
+			}
+			errc <- peer.Remove(ctx, req, &pb.RemoveResponse{})
+		}(peer)
+	}
+	var err error
+	for range peers {
+		if perr := <-errc; perr != nil && err == nil && !errors.Is(perr, ErrPeerUnsupported) {
+			err = perr
+		}
+	}
+	return err
+}
+
 func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
+	value, _, ok = g.lookupCacheTier(key)
+	return
+}
+
+// lookupCacheTier is like lookupCache, but also returns the cache
+// holding key.
+func (g *Group) lookupCacheTier(key string) (value ByteView, which CacheType, ok bool) {
 	if g.cacheBytes <= 0 {
 		return
 	}
-	value, ok = g.mainCache.get(key)
+	if value, ok = g.mainCache.get(key); ok {
+		return value, MainCache, true
+	}
+	if value, ok = g.hotCache.get(key); ok {
+		return value, HotCache, true
+	}
+	if value, ok = g.lookupDisk(key); ok {
+		return value, DiskCache, true
+	}
+	return
+}
+
+// lookupError returns the unexpired load error remembered for key.
+func (g *Group) lookupError(key string) (error, bool) {
+	err, _, ok := g.errCache.get(key, nowFunc())
 	if ok {
+		g.Stats.ErrorHits.Add(1)
+	}
+	return err, ok
+}
+
+// rememberError caches err, returned by the Getter for key, if
+// negative caching is enabled and err is cacheable.
+func (g *Group) rememberError(key string, err error) {
+	if g.opts.ErrorTTL <= 0 {
 		return
 	}
-	value, ok = g.hotCache.get(key)
-	return
+	cacheable := g.opts.CacheError
+	if cacheable == nil {
+		cacheable = cacheableError
+	}
+	if !cacheable(key, err) {
+		return
+	}
+	maxTTL := g.opts.MaxErrorTTL
+	if maxTTL < g.opts.ErrorTTL {
+		maxTTL = g.opts.ErrorTTL
+	}
+	g.errCache.add(key, err, g.opts.ErrorTTL, maxTTL, nowFunc())
+}
+
+// cachedError returns the load error remembered for key and when it
+// expires, so that it can be passed on to the peer asking for key.
+func (g *Group) cachedError(key string) (err error, expire time.Time, ok bool) {
+	return g.errCache.get(key, nowFunc())
 }
 
 func (g *Group) populateCache(key string, value ByteView, cache *cache) {
-	if g.cacheBytes <= 0 {
+	if g.cacheBytes <= 0 || value.expired(nowFunc().Add(-g.opts.StaleWhileRevalidate)) {
 		return
 	}
 	cache.add(key, value)
+	if cache == &g.mainCache && g.disk != nil {
+		// The disk copy, if any, is now stale or redundant.
+		g.disk.remove(key)
+	}
 
 	// Evict items from cache(s) if necessary.
 	for {
@@ -347,14 +874,18 @@ func (g *Group) populateCache(key string, value ByteView, cache *cache) {
 			return
 		}
 
-		// TODO(bradfitz): this is good-enough-for-now logic.
-		// It should be something based on measurements and/or
-		// respecting the costs of different resources.
-		victim := &g.mainCache
-		if hotBytes > mainBytes/8 {
-			victim = &g.hotCache
+		victim, which := &g.mainCache, MainCache
+		if hotBytes > g.split.hotTarget(g.cacheBytes) {
+			victim, which = &g.hotCache, HotCache
+		}
+		key, value, ok := victim.removeOldest()
+		if !ok {
+			continue
+		}
+		g.observe(Event{Type: Eviction, Key: key, Cache: which})
+		if which == MainCache {
+			g.spill(key, value)
 		}
-		victim.removeOldest()
 	}
 }
 
@@ -370,99 +901,384 @@ const (
 	// enough to replicate to this node, even though it's not the
 	// owner.
 	HotCache
+
+	// The DiskCache is the disk tier holding items evicted from
+	// the MainCache. See GroupOptions.DiskDir.
+	DiskCache
 )
 
+func (ct CacheType) String() string {
+	switch ct {
+	case MainCache:
+		return "main"
+	case HotCache:
+		return "hot"
+	case DiskCache:
+		return "disk"
+	}
+	return fmt.Sprintf("CacheType(%d)", int(ct))
+}
+
 // CacheStats returns stats about the provided cache within the group.
 func (g *Group) CacheStats(which CacheType) CacheStats {
+	hotTarget := g.split.hotTarget(g.cacheBytes)
 	switch which {
 	case MainCache:
-		return g.mainCache.stats()
+		st := g.mainCache.stats()
+		st.TargetBytes = g.cacheBytes - hotTarget
+		return st
 	case HotCache:
-		return g.hotCache.stats()
+		st := g.hotCache.stats()
+		st.TargetBytes = hotTarget
+		return st
+	case DiskCache:
+		if g.disk == nil {
+			return CacheStats{}
+		}
+		return g.disk.stats()
 	default:
 		return CacheStats{}
 	}
 }
 
-// cache is a wrapper around an *lru.Cache that adds synchronization,
-// makes values always be ByteView, and counts the size of all keys and
-// values.
+// cache is a set of cacheShards, each holding the keys that hash to
+// it behind its own lock, so that lookups of different keys don't
+// contend. The shards share the byte budget of the cache: evictions
+// are taken from the largest shard.
 type cache struct {
-	mu         sync.RWMutex
-	nbytes     int64 // of all keys and values
-	lru        *lru.Cache
-	nhit, nget int64
-	nevict     int64 // number of evictions
+	initOnce sync.Once
+	shards   []*cacheShard
+
+	// The fields below configure the shards. They are set when
+	// the group is created.
+
+	// nshards is the number of shards. Zero means one.
+	nshards int
+
+	// newPolicy creates the eviction policy of each shard. If nil,
+	// NewLRU is used.
+	newPolicy func() EvictionPolicy
+
+	// grace is how long past their expiration values are still
+	// returned by get. See GroupOptions.StaleWhileRevalidate.
+	grace time.Duration
+
+	// maxGhostBytes bounds the sizes of the recently evicted
+	// entries remembered by all shards. See GroupOptions.AdaptiveHotCache.
+	maxGhostBytes int64
+}
+
+func (c *cache) init() {
+	c.initOnce.Do(func() {
+		n := c.nshards
+		if n < 1 {
+			n = 1
+		}
+		c.shards = make([]*cacheShard, n)
+		for i := range c.shards {
+			c.shards[i] = &cacheShard{
+				newPolicy:     c.newPolicy,
+				grace:         c.grace,
+				maxGhostBytes: c.maxGhostBytes / int64(n),
+			}
+		}
+	})
+}
+
+// shard returns the shard holding key.
+func (c *cache) shard(key string) *cacheShard {
+	c.init()
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	return c.shards[keyHash(key)%uint64(len(c.shards))]
 }
 
 func (c *cache) stats() CacheStats {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return CacheStats{
-		Bytes:     c.nbytes,
-		Items:     c.itemsLocked(),
-		Gets:      c.nget,
-		Hits:      c.nhit,
-		Evictions: c.nevict,
+	c.init()
+	var st CacheStats
+	for _, s := range c.shards {
+		st.Bytes += s.nbytes.Load()
+		st.Items += s.items()
+		st.Gets += s.nget.Load()
+		st.Hits += s.nhit.Load()
+		st.Evictions += s.nevict.Load()
 	}
+	return st
 }
 
 func (c *cache) add(key string, value ByteView) {
+	c.shard(key).add(key, value)
+}
+
+func (c *cache) get(key string) (value ByteView, ok bool) {
+	return c.shard(key).get(key)
+}
+
+// has reports whether key is cached, without counting as a get.
+func (c *cache) has(key string) bool {
+	return c.shard(key).has(key)
+}
+
+func (c *cache) remove(key string) {
+	c.shard(key).remove(key)
+}
+
+// ghostHit reports whether key was evicted recently, and forgets it.
+// It returns the size of key's entry when it was evicted.
+func (c *cache) ghostHit(key string) (size int64, ok bool) {
+	return c.shard(key).ghostHit(key)
+}
+
+func (c *cache) clear() {
+	c.init()
+	for _, s := range c.shards {
+		s.clear()
+	}
+}
+
+// removeOldest evicts the entry chosen by the eviction policy of the
+// largest shard, and returns it.
+func (c *cache) removeOldest() (key string, value ByteView, ok bool) {
+	c.init()
+	victim := c.shards[0]
+	for _, s := range c.shards[1:] {
+		if s.bytes() > victim.bytes() {
+			victim = s
+		}
+	}
+	return victim.removeOldest()
+}
+
+// entries returns the entries of each shard, in the order of the
+// shard's EvictionPolicy.Range.
+func (c *cache) entries() (keys []string, values []ByteView) {
+	c.init()
+	for _, s := range c.shards {
+		s.mu.RLock()
+		if s.policy != nil {
+			s.policy.Range(func(key string, value ByteView) bool {
+				keys = append(keys, key)
+				values = append(values, value)
+				return true
+			})
+		}
+		s.mu.RUnlock()
+	}
+	return keys, values
+}
+
+func (c *cache) bytes() int64 {
+	c.init()
+	var n int64
+	for _, s := range c.shards {
+		n += s.bytes()
+	}
+	return n
+}
+
+func (c *cache) items() int64 {
+	c.init()
+	var n int64
+	for _, s := range c.shards {
+		n += s.items()
+	}
+	return n
+}
+
+// cacheShard is a wrapper around an EvictionPolicy that adds
+// synchronization, makes values always be ByteView, and counts the
+// size of all keys and values.
+//
+// If the policy implements SharedGetter, lookups only take a read
+// lock.
+type cacheShard struct {
+	nbytes     atomic.Int64 // of all keys and values
+	nhit, nget atomic.Int64
+	nevict     atomic.Int64 // number of evictions
+
+	newPolicy     func() EvictionPolicy
+	grace         time.Duration
+	maxGhostBytes int64
+
+	mu     sync.RWMutex
+	policy EvictionPolicy
+
+	// ghosts remembers the sizes of the entries recently evicted,
+	// up to maxGhostBytes in all.
+	ghosts     *lru.Cache
+	ghostBytes int64
+}
+
+func (c *cacheShard) add(key string, value ByteView) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.lru == nil {
-		c.lru = &lru.Cache{
-			OnEvicted: func(key lru.Key, value interface{}) {
-				val := value.(ByteView)
-				c.nbytes -= int64(len(key.(string))) + int64(val.Len())
-				c.nevict++
-			},
+	if c.policy == nil {
+		if c.newPolicy != nil {
+			c.policy = c.newPolicy()
+		} else {
+			c.policy = NewLRU()
 		}
 	}
-	c.lru.Add(key, value)
-	c.nbytes += int64(len(key)) + int64(value.Len())
+	if old, ok := c.policy.Add(key, value); ok {
+		c.nbytes.Add(-entrySize(key, old))
+	}
+	c.nbytes.Add(entrySize(key, value))
+	if c.ghosts != nil {
+		c.ghosts.Remove(key)
+	}
 }
 
-func (c *cache) get(key string) (value ByteView, ok bool) {
+func (c *cacheShard) get(key string) (value ByteView, ok bool) {
+	c.nget.Add(1)
+	value, ok = c.lookup(key)
+	if !ok {
+		return
+	}
+	if value.expired(nowFunc().Add(-c.grace)) {
+		c.removeExpired(key, value)
+		return ByteView{}, false
+	}
+	c.nhit.Add(1)
+	return value, true
+}
+
+// removeExpired drops key if it still holds value, found expired
+// without the write lock. A value stored for key since is kept.
+// Expirations don't count as evictions.
+func (c *cacheShard) removeExpired(key string, value ByteView) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.nget++
-	if c.lru == nil {
+	if c.policy == nil {
 		return
 	}
-	vi, ok := c.lru.Get(key)
-	if !ok {
+	cur, ok := c.policy.Get(key)
+	if !ok || !cur.e.Equal(value.e) || !cur.Equal(value) || !cur.expired(nowFunc().Add(-c.grace)) {
 		return
 	}
-	c.nhit++
-	return vi.(ByteView), true
+	if cur, ok := c.policy.Remove(key); ok {
+		c.nbytes.Add(-entrySize(key, cur))
+	}
 }
 
-func (c *cache) removeOldest() {
+// lookup returns key's value, recording the access with the policy.
+func (c *cacheShard) lookup(key string) (value ByteView, ok bool) {
+	c.mu.RLock()
+	if sg, shared := c.policy.(SharedGetter); shared {
+		value, ok = sg.GetShared(key)
+		c.mu.RUnlock()
+		return
+	}
+	c.mu.RUnlock()
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.lru != nil {
-		c.lru.RemoveOldest()
+	if c.policy == nil {
+		return
 	}
+	return c.policy.Get(key)
 }
 
-func (c *cache) bytes() int64 {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.nbytes
+// has reports whether key is cached, without counting as a get.
+func (c *cacheShard) has(key string) bool {
+	_, ok := c.lookup(key)
+	return ok
 }
 
-func (c *cache) items() int64 {
+func (c *cacheShard) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.policy == nil {
+		return
+	}
+	if value, ok := c.policy.Remove(key); ok {
+		c.nbytes.Add(-entrySize(key, value))
+		c.nevict.Add(1)
+	}
+}
+
+func (c *cacheShard) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = nil
+	c.nbytes.Store(0)
+	c.ghosts = nil
+	c.ghostBytes = 0
+}
+
+func (c *cacheShard) removeOldest() (key string, value ByteView, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.policy == nil {
+		return
+	}
+	key, value, ok = c.policy.Evict()
+	if ok {
+		c.nbytes.Add(-entrySize(key, value))
+		c.nevict.Add(1)
+		c.addGhostLocked(key, entrySize(key, value))
+	}
+	return
+}
+
+func (c *cacheShard) addGhostLocked(key string, size int64) {
+	if c.maxGhostBytes <= 0 {
+		return
+	}
+	if c.ghosts == nil {
+		c.ghosts = &lru.Cache{
+			OnEvicted: func(key lru.Key, value interface{}) {
+				c.ghostBytes -= value.(int64)
+			},
+		}
+	}
+	c.ghosts.Add(key, size)
+	c.ghostBytes += size
+	for c.ghostBytes > c.maxGhostBytes {
+		c.ghosts.RemoveOldest()
+	}
+}
+
+func (c *cacheShard) ghostHit(key string) (size int64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ghosts == nil {
+		return 0, false
+	}
+	v, ok := c.ghosts.Get(key)
+	if !ok {
+		return 0, false
+	}
+	c.ghosts.Remove(key)
+	return v.(int64), true
+}
+
+func (c *cacheShard) bytes() int64 {
+	return c.nbytes.Load()
+}
+
+func (c *cacheShard) items() int64 {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return c.itemsLocked()
+	if c.policy == nil {
+		return 0
+	}
+	return int64(c.policy.Len())
 }
 
-func (c *cache) itemsLocked() int64 {
-	if c.lru == nil {
-		return 0
+// keyHash returns the 64-bit FNV-1a hash of key.
+func keyHash(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
 	}
-	return int64(c.lru.Len())
+	return h
+}
+
+// entrySize returns the number of bytes key and value count for.
+func entrySize(key string, value ByteView) int64 {
+	return int64(len(key)) + int64(value.Len())
 }
 
 // An AtomicInt is an int64 to be accessed atomically.
@@ -484,6 +1300,10 @@ func (i *AtomicInt) String() string {
 
 // CacheStats are returned by stats accessors on Group.
 type CacheStats struct {
+	// TargetBytes is the share of the group's cacheBytes this
+	// cache may use. See GroupOptions.AdaptiveHotCache.
+	TargetBytes int64
+
 	Bytes     int64
 	Items     int64
 	Gets      int64
@@ -23,9 +23,10 @@ import (
 	"errors"
 	"fmt"
 	"hash/crc32"
-	"math/rand"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
@@ -33,6 +34,7 @@ import (
 	"github.com/golang/protobuf/proto"
 
 	pb "github.com/golang/groupcache/groupcachepb"
+	"github.com/golang/groupcache/singleflight"
 	testpb "github.com/golang/groupcache/testpb"
 )
 
@@ -203,7 +205,7 @@ func TestCacheEviction(t *testing.T) {
 	}
 
 	g := stringGroup.(*Group)
-	evict0 := g.mainCache.nevict
+	evict0 := g.mainCache.stats().Evictions
 
 	// Trash the cache with other keys.
 	var bytesFlooded int64
@@ -214,7 +216,7 @@ func TestCacheEviction(t *testing.T) {
 		stringGroup.Get(dummyCtx, key, StringSink(&res))
 		bytesFlooded += int64(len(key) + len(res))
 	}
-	evicts := g.mainCache.nevict - evict0
+	evicts := g.mainCache.stats().Evictions - evict0
 	if evicts <= 0 {
 		t.Errorf("evicts = %v; want more than 0", evicts)
 	}
@@ -227,16 +229,58 @@ func TestCacheEviction(t *testing.T) {
 }
 
 type fakePeer struct {
-	hits int
-	fail bool
+	qps       float64 // reported as every key's minute_qps
+	hits      int
+	fallbacks int // Gets asking the peer to fill in for the owner
+	batches   int
+	removes   int
+	sets      map[string]*pb.SetRequest
+	fail      bool
 }
 
 func (p *fakePeer) Get(_ context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
 	p.hits++
+	if in.GetFallback() {
+		p.fallbacks++
+	}
 	if p.fail {
 		return errors.New("simulated error from peer")
 	}
 	out.Value = []byte("got:" + in.GetKey())
+	if p.qps != 0 {
+		out.MinuteQps = proto.Float64(p.qps)
+	}
+	return nil
+}
+
+func (p *fakePeer) Set(_ context.Context, in *pb.SetRequest, out *pb.SetResponse) error {
+	if p.fail {
+		return errors.New("simulated error from peer")
+	}
+	if p.sets == nil {
+		p.sets = make(map[string]*pb.SetRequest)
+	}
+	p.sets[in.GetKey()] = in
+	return nil
+}
+
+func (p *fakePeer) Remove(_ context.Context, in *pb.RemoveRequest, out *pb.RemoveResponse) error {
+	p.removes++
+	if p.fail {
+		return errors.New("simulated error from peer")
+	}
+	return nil
+}
+
+func (p *fakePeer) GetMulti(_ context.Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error {
+	p.batches++
+	if p.fail {
+		return errors.New("simulated error from peer")
+	}
+	for _, key := range in.GetKeys() {
+		p.hits++
+		out.Responses = append(out.Responses, &pb.GetResponse{Value: []byte("got:" + key)})
+	}
 	return nil
 }
 
@@ -250,10 +294,34 @@ func (p fakePeers) PickPeer(key string) (peer ProtoGetter, ok bool) {
 	return p[n], p[n] != nil
 }
 
+func (p fakePeers) ListPeers() []ProtoGetter {
+	var peers []ProtoGetter
+	for _, peer := range p {
+		if peer != nil {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// fallbackPeers owns every key by owner, followed by fallbacks.
+type fallbackPeers struct {
+	owner     ProtoGetter
+	fallbacks []ProtoGetter
+}
+
+func (p fallbackPeers) PickPeer(key string) (ProtoGetter, bool) { return p.owner, true }
+
+func (p fallbackPeers) PickFallbacks(key string, n int) []ProtoGetter {
+	if n > len(p.fallbacks) {
+		n = len(p.fallbacks)
+	}
+	return p.fallbacks[:n]
+}
+
 // TestPeers tests that peers (virtual, in-process) are hit, and how much.
 func TestPeers(t *testing.T) {
 	once.Do(testSetup)
-	rand.Seed(123)
 	peer0 := &fakePeer{}
 	peer1 := &fakePeer{}
 	peer2 := &fakePeer{}
@@ -303,9 +371,9 @@ func TestPeers(t *testing.T) {
 	resetCacheSize(1 << 20)
 	run("base", 200, "localHits = 49, peers = 51 49 51")
 
-	// Verify cache was hit.  All localHits are gone, and some of
-	// the peer hits (the ones randomly selected to be maybe hot)
-	run("cached_base", 200, "localHits = 0, peers = 49 47 48")
+	// Verify cache was hit.  All localHits are gone, but no key
+	// is requested often enough to be mirrored in the hot cache.
+	run("cached_base", 200, "localHits = 0, peers = 51 49 51")
 	resetCacheSize(0)
 
 	// With one of the peers being down.
@@ -322,6 +390,483 @@ func TestPeers(t *testing.T) {
 	run("peer0_failing", 200, "localHits = 100, peers = 51 49 51")
 }
 
+// keyOwnedBy returns a key that peers map to peers[n].
+func keyOwnedBy(peers fakePeers, n int) string {
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if crc32.ChecksumIEEE([]byte(key))%uint32(len(peers)) == uint32(n) {
+			return key
+		}
+	}
+}
+
+// keysOwnedBy returns n keys that peers map to peers[owner].
+func keysOwnedBy(peers fakePeers, owner, n int) (keys []string) {
+	for i := 0; len(keys) < n; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if crc32.ChecksumIEEE([]byte(key))%uint32(len(peers)) == uint32(owner) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestAdaptiveHotCache(t *testing.T) {
+	peers := fakePeers{&fakePeer{}, nil}
+	const cacheBytes = 1000
+	g := DefaultRegistry.newGroup("TestAdaptiveHotCache-group", cacheBytes, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetBytes(make([]byte, 90))
+	}), peers, &GroupOptions{
+		HotCacheQPS:      -1, // only Set fills the hot cache
+		AdaptiveHotCache: true,
+	})
+	targets := func() (main, hot int64) {
+		return g.CacheStats(MainCache).TargetBytes, g.CacheStats(HotCache).TargetBytes
+	}
+	get := func(key string) {
+		t.Helper()
+		var v ByteView
+		if err := g.Get(dummyCtx, key, ByteViewSink(&v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	main0, hot0 := targets()
+	if main0+hot0 != cacheBytes || hot0 != cacheBytes/9 {
+		t.Fatalf("initial targets = %d, %d; want %d, %d", main0, hot0, cacheBytes-cacheBytes/9, cacheBytes/9)
+	}
+
+	// Fill the main cache, then mirror keys into the hot cache.
+	// Over budget, the hot cache gives way.
+	for _, key := range keysOwnedBy(peers, 1, 10) {
+		get(key)
+	}
+	hotKeys := keysOwnedBy(peers, 0, 10)
+	for _, key := range hotKeys {
+		if err := g.Set(dummyCtx, key, make([]byte, 90), time.Time{}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := g.CacheStats(HotCache).Evictions; n == 0 {
+		t.Fatal("no hot cache evictions")
+	}
+
+	// Missing the keys the hot cache evicted grows its share.
+	for _, key := range hotKeys {
+		get(key)
+	}
+	main1, hot1 := targets()
+	if hot1 <= hot0 || main1+hot1 != cacheBytes {
+		t.Errorf("targets after hot cache ghost hits = %d, %d; want the hot one above %d", main1, hot1, hot0)
+	}
+
+	// Now the main cache gives way, and missing its evicted keys
+	// grows its share back.
+	for _, key := range hotKeys {
+		if err := g.Set(dummyCtx, key, make([]byte, 90), time.Time{}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := g.CacheStats(MainCache).Evictions; n == 0 {
+		t.Fatal("no main cache evictions")
+	}
+	for _, key := range keysOwnedBy(peers, 1, 10) {
+		get(key)
+	}
+	main2, hot2 := targets()
+	if main2 <= main1 || main2+hot2 != cacheBytes {
+		t.Errorf("targets after main cache ghost hits = %d, %d; want the main one above %d", main2, hot2, main1)
+	}
+}
+
+func TestAdaptiveHotCacheBounds(t *testing.T) {
+	const cacheBytes = 1600
+	for _, tt := range []struct {
+		ratio    float64
+		min, max int64
+	}{
+		{0, cacheBytes / 16, cacheBytes / 2},
+		{0.75, cacheBytes / 16, cacheBytes * 3 / 4},
+		{0.01, cacheBytes / 100, cacheBytes / 2},
+	} {
+		var s cacheSplit
+		s.init(tt.ratio)
+		s.moveHot(-cacheBytes, cacheBytes)
+		if got := s.hotTarget(cacheBytes); got != tt.min {
+			t.Errorf("ratio %v: hot target after shrinking = %d; want %d", tt.ratio, got, tt.min)
+		}
+		s.moveHot(cacheBytes, cacheBytes)
+		if got := s.hotTarget(cacheBytes); got != tt.max {
+			t.Errorf("ratio %v: hot target after growing = %d; want %d", tt.ratio, got, tt.max)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	peer0 := &fakePeer{}
+	peers := fakePeers{peer0, nil}
+	fills := 0
+	g := newGroup("TestRemove-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		fills++
+		return dest.SetString("local:" + key)
+	}), peers)
+
+	// A key this process owns is evicted from mainCache and the
+	// invalidation is fanned out to every peer.
+	localKey := keyOwnedBy(peers, 1)
+	var s string
+	for i := 0; i < 2; i++ {
+		if err := g.Get(dummyCtx, localKey, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Remove(dummyCtx, localKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.lookupCache(localKey); ok {
+		t.Error("local key still cached after Remove")
+	}
+	if peer0.removes != 1 {
+		t.Errorf("peer0 got %d removes; want 1 from the fan-out", peer0.removes)
+	}
+	if err := g.Get(dummyCtx, localKey, StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if fills != 2 {
+		t.Errorf("fills = %d; want 2", fills)
+	}
+
+	// A key owned by a peer is evicted from hotCache and the
+	// invalidation is sent to the owner.
+	remoteKey := keyOwnedBy(peers, 0)
+	g.populateCache(remoteKey, ByteView{s: "hot"}, &g.hotCache)
+	if err := g.Remove(dummyCtx, remoteKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.lookupCache(remoteKey); ok {
+		t.Error("remote key still in hotCache after Remove")
+	}
+	if peer0.removes != 2 {
+		t.Errorf("peer0 got %d removes; want 2", peer0.removes)
+	}
+
+	// A fan-out received from the owner is not forwarded again.
+	if err := g.serveRemove(dummyCtx, remoteKey); err != nil {
+		t.Fatal(err)
+	}
+	if peer0.removes != 2 {
+		t.Errorf("peer0 got %d removes after serving the owner's fan-out; want 2", peer0.removes)
+	}
+
+	peer0.fail = true
+	if err := g.Remove(dummyCtx, remoteKey); err == nil {
+		t.Error("Remove succeeded with a failing owner")
+	}
+}
+
+// getOnlyPeer is a peer that only implements ProtoGetter.
+type getOnlyPeer struct{ p *fakePeer }
+
+func (p getOnlyPeer) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	return p.p.Get(ctx, in, out)
+}
+
+func TestGetOnlyPeer(t *testing.T) {
+	owner, other := &fakePeer{}, &fakePeer{}
+	peers := fakePeers{getOnlyPeer{owner}, other, nil}
+	g := newGroup("TestGetOnlyPeer-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("local:" + key)
+	}), peers)
+	key := keyOwnedBy(peers, 0)
+
+	if err := g.Set(dummyCtx, key, []byte("v"), time.Time{}, false); err != ErrPeerUnsupported {
+		t.Errorf("Set error = %v; want %v", err, ErrPeerUnsupported)
+	}
+
+	// Remove can't go through the owner, so it goes to the peers
+	// that can evict keys.
+	if err := g.Remove(dummyCtx, key); err != nil {
+		t.Fatal(err)
+	}
+	if other.removes != 1 {
+		t.Errorf("other peer got %d removes; want 1", other.removes)
+	}
+
+	// The owner's keys are asked for one by one.
+	var a, b string
+	if err := g.GetMulti(dummyCtx, []string{key, key + "x"}, []Sink{StringSink(&a), StringSink(&b)}); err != nil {
+		t.Fatal(err)
+	}
+	if a != "got:"+key || owner.batches != 0 || owner.hits == 0 {
+		t.Errorf("value = %q, owner batches = %d, hits = %d; want %q from single Gets", a, owner.batches, owner.hits, "got:"+key)
+	}
+}
+
+func TestSet(t *testing.T) {
+	peer0 := &fakePeer{}
+	peers := fakePeers{peer0, nil}
+	g := newGroup("TestSet-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}), peers)
+	expire := time.Now().Add(time.Hour).Round(0)
+
+	// A key this process owns goes straight into mainCache.
+	localKey := keyOwnedBy(peers, 1)
+	if err := g.Set(dummyCtx, localKey, []byte("local-value"), time.Time{}, false); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := g.Get(dummyCtx, localKey, StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "local-value" {
+		t.Errorf("Get = %q; want %q", s, "local-value")
+	}
+	if len(peer0.sets) != 0 {
+		t.Errorf("peer0 got %d sets for a locally owned key; want 0", len(peer0.sets))
+	}
+
+	// Replacing a value keeps the byte accounting exact.
+	if err := g.Set(dummyCtx, localKey, []byte("v2"), time.Time{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(localKey) + len("v2")); g.mainCache.bytes() != want {
+		t.Errorf("mainCache has %d bytes; want %d", g.mainCache.bytes(), want)
+	}
+
+	// A key owned by a peer is sent to it, and optionally mirrored.
+	remoteKey := keyOwnedBy(peers, 0)
+	if err := g.Set(dummyCtx, remoteKey, []byte("remote-value"), expire, true); err != nil {
+		t.Fatal(err)
+	}
+	req := peer0.sets[remoteKey]
+	if req == nil {
+		t.Fatal("owner did not receive the Set")
+	}
+	if got := string(req.GetValue()); got != "remote-value" {
+		t.Errorf("owner got value %q; want %q", got, "remote-value")
+	}
+	if got := time.Unix(0, req.GetExpire()); !got.Equal(expire) {
+		t.Errorf("owner got expire %v; want %v", got, expire)
+	}
+	if v, ok := g.hotCache.get(remoteKey); !ok || v.String() != "remote-value" {
+		t.Errorf("hotCache = %q, %v; want %q, true", v, ok, "remote-value")
+	}
+
+	// Without hotCache, a stale mirrored copy is dropped.
+	if err := g.Set(dummyCtx, remoteKey, []byte("remote-value2"), expire, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.hotCache.get(remoteKey); ok {
+		t.Error("stale value still in hotCache")
+	}
+
+	peer0.fail = true
+	if err := g.Set(dummyCtx, remoteKey, []byte("x"), time.Time{}, true); err == nil {
+		t.Error("Set succeeded with a failing owner")
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	peer0 := &fakePeer{}
+	peer1 := &fakePeer{}
+	peers := fakePeers{peer0, peer1, nil}
+	var localLoads AtomicInt
+	errBad := errors.New("bad key")
+	g := newGroup("TestGetMulti-group", 0, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		localLoads.Add(1)
+		if key == "bad" {
+			return errBad
+		}
+		return dest.SetString("got:" + key)
+	}), peers)
+
+	var keys []string
+	local := 0
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if _, ok := peers.PickPeer(key); !ok {
+			local++
+		}
+		keys = append(keys, key)
+	}
+	keys = append(keys, "key-0") // duplicates are loaded once
+
+	getMulti := func() ([]string, error) {
+		values := make([]string, len(keys))
+		dests := make([]Sink, len(keys))
+		for i := range keys {
+			dests[i] = StringSink(&values[i])
+		}
+		return values, g.GetMulti(dummyCtx, keys, dests)
+	}
+	check := func(name string, values []string) {
+		t.Helper()
+		for i, key := range keys {
+			if want := "got:" + key; values[i] != want && key != "bad" {
+				t.Errorf("%s: value of %q = %q; want %q", name, key, values[i], want)
+			}
+		}
+	}
+
+	values, err := getMulti()
+	if err != nil {
+		t.Fatalf("GetMulti: %v", err)
+	}
+	check("base", values)
+	if peer0.batches != 1 || peer1.batches != 1 {
+		t.Errorf("batches = %d, %d; want 1 request per peer", peer0.batches, peer1.batches)
+	}
+	if got := int(localLoads.Get()); got != local {
+		t.Errorf("local loads = %d; want %d", got, local)
+	}
+	if got := peer0.hits + peer1.hits + local; got != 30 {
+		t.Errorf("keys loaded = %d; want 30", got)
+	}
+
+	// Keys of a failing peer are loaded locally.
+	peer1.fail = true
+	peer1Keys := peer1.hits
+	localLoads = 0
+	values, err = getMulti()
+	if err != nil {
+		t.Fatalf("GetMulti with failing peer: %v", err)
+	}
+	check("peer1_failing", values)
+	if got, want := int(localLoads.Get()), local+peer1Keys; got != want {
+		t.Errorf("local loads with failing peer = %d; want %d", got, want)
+	}
+	if peer1.batches != 2 {
+		t.Errorf("peer1 batches = %d; want 2", peer1.batches)
+	}
+
+	// Errors are reported per key.
+	keys = append(keys, "bad")
+	values, err = getMulti()
+	merr, ok := err.(MultiError)
+	if !ok {
+		t.Fatalf("GetMulti error = %v; want a MultiError", err)
+	}
+	check("bad_key", values)
+	for i, err := range merr {
+		if want := error(nil); keys[i] == "bad" {
+			want = errBad
+			if err != want {
+				t.Errorf("error of %q = %v; want %v", keys[i], err, want)
+			}
+		} else if err != nil {
+			t.Errorf("error of %q = %v; want nil", keys[i], err)
+		}
+	}
+
+	if err := g.GetMulti(dummyCtx, keys, nil); err == nil {
+		t.Error("GetMulti with missing sinks succeeded; want error")
+	}
+}
+
+func TestHotCacheAdmission(t *testing.T) {
+	clock, restore := useFakeClock(time.Unix(1000, 0))
+	defer restore()
+
+	peer0 := &fakePeer{}
+	g := DefaultRegistry.newGroup("TestHotCacheAdmission-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}), fakePeers{peer0}, &GroupOptions{HotCacheQPS: 5})
+
+	get := func(key string) {
+		t.Helper()
+		var s string
+		if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mirrored := func(key string) bool {
+		return g.hotCache.has(key)
+	}
+
+	// A key that's hot on the owner is mirrored once this process
+	// requests it twice, but a one-off request isn't.
+	peer0.qps = 10
+	get("popular")
+	if mirrored("popular") {
+		t.Error("popular key mirrored after one request")
+	}
+	clock.Advance(10 * time.Second)
+	get("popular")
+	if !mirrored("popular") {
+		t.Error("popular key not mirrored after two requests")
+	}
+
+	// A cold key requested twice isn't mirrored.
+	peer0.qps = 1
+	get("cold")
+	get("cold")
+	if mirrored("cold") {
+		t.Error("cold key mirrored")
+	}
+
+	// A key hot locally is mirrored whatever the owner says.
+	peer0.qps = 0
+	for i := 0; i < 5*60; i++ {
+		get("local")
+		if mirrored("local") {
+			break
+		}
+	}
+	if !mirrored("local") {
+		t.Error("locally hot key not mirrored")
+	}
+}
+
+func TestRateTracker(t *testing.T) {
+	var rt rateTracker
+	now := time.Unix(1000, 0)
+	for i := 0; i < 60; i++ {
+		rt.add("k", now)
+	}
+	if got := rt.rate("k", now); got != 1 {
+		t.Errorf("rate after 60 requests = %v; want 1", got)
+	}
+	// Half way through the next window, half of the previous one
+	// still counts.
+	if got := rt.rate("k", now.Add(90*time.Second)); got != 0.5 {
+		t.Errorf("rate 90s later = %v; want 0.5", got)
+	}
+	if got := rt.rate("k", now.Add(5*time.Minute)); got != 0 {
+		t.Errorf("rate 5m later = %v; want 0", got)
+	}
+	if got := rt.rate("other", now); got != 0 {
+		t.Errorf("rate of untracked key = %v; want 0", got)
+	}
+}
+
+func TestRateTrackerShards(t *testing.T) {
+	rt := rateTracker{nshards: 4}
+	now := time.Unix(1000, 0)
+	for i := 0; i < 100; i++ {
+		rt.add(fmt.Sprintf("k%d", i), now)
+	}
+	for i := 0; i < 100; i++ {
+		if got := rt.rate(fmt.Sprintf("k%d", i), now); got != 1.0/60 {
+			t.Fatalf("rate of k%d = %v; want %v", i, got, 1.0/60)
+		}
+	}
+	total := 0
+	for i, s := range rt.shards {
+		if n := s.lru.Len(); n == 0 {
+			t.Errorf("shard %d tracks no keys", i)
+		} else {
+			total += n
+		}
+		if s.lru.MaxEntries != maxRateEntries/4 {
+			t.Errorf("shard %d bounded to %d keys; want %d", i, s.lru.MaxEntries, maxRateEntries/4)
+		}
+	}
+	if total != 100 {
+		t.Errorf("shards track %d keys; want 100", total)
+	}
+}
+
 func TestTruncatingByteSliceTarget(t *testing.T) {
 	var buf [100]byte
 	s := buf[:]
@@ -366,7 +911,7 @@ func TestAllocatingByteSliceTarget(t *testing.T) {
 }
 
 // orderedFlightGroup allows the caller to force the schedule of when
-// orig.Do will be called.  This is useful to serialize calls such
+// orig.Do or orig.DoContext will be called.  This is useful to serialize calls such
 // that singleflight cannot dedup them.
 type orderedFlightGroup struct {
 	mu     sync.Mutex
@@ -375,7 +920,7 @@ type orderedFlightGroup struct {
 	orig   flightGroup
 }
 
-func (g *orderedFlightGroup) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+func (g *orderedFlightGroup) Do(key string, fn func() (ByteView, error)) (ByteView, error, bool) {
 	<-g.stage1
 	<-g.stage2
 	g.mu.Lock()
@@ -383,6 +928,14 @@ func (g *orderedFlightGroup) Do(key string, fn func() (interface{}, error)) (int
 	return g.orig.Do(key, fn)
 }
 
+func (g *orderedFlightGroup) DoContext(ctx context.Context, key string, fn func(context.Context) (ByteView, error)) (ByteView, error, bool) {
+	<-g.stage1
+	<-g.stage2
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.orig.DoContext(ctx, key, fn)
+}
+
 // TestNoDedup tests invariants on the cache size when singleflight is
 // unable to dedup calls.
 func TestNoDedup(t *testing.T) {
@@ -440,8 +993,663 @@ func TestNoDedup(t *testing.T) {
 	// upon entry, we would increment nbytes twice but the entry would
 	// only be in the cache once.
 	const wantBytes = int64(len(testkey) + len(testval))
-	if g.mainCache.nbytes != wantBytes {
-		t.Errorf("cache has %d bytes, want %d", g.mainCache.nbytes, wantBytes)
+	if g.mainCache.bytes() != wantBytes {
+		t.Errorf("cache has %d bytes, want %d", g.mainCache.bytes(), wantBytes)
+	}
+}
+
+func TestLoadOutlivesCaller(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	loadCtx := make(chan context.Context, 1)
+	g := newGroup("TestLoadOutlivesCaller-group", cacheSize, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loadCtx <- ctx
+		close(started)
+		<-release
+		return dest.SetString("got:" + key)
+	}), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		var s string
+		errc <- g.Get(ctx, "key", StringSink(&s))
+	}()
+	<-started
+	resc := make(chan string, 1)
+	go func() {
+		var s string
+		if err := g.Get(context.Background(), "key", StringSink(&s)); err != nil {
+			t.Errorf("second Get: %v", err)
+		}
+		resc <- s
+	}()
+	time.Sleep(50 * time.Millisecond) // let the second Get wait for the load
+
+	// The impatient caller leaves without failing the load.
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("cancelled Get error = %v; want %v", err, context.Canceled)
+	}
+	if err := (<-loadCtx).Err(); err != nil {
+		t.Errorf("load's context done while a caller waits: %v", err)
+	}
+	close(release)
+	if s := <-resc; s != "got:key" {
+		t.Errorf("second Get = %q; want %q", s, "got:key")
+	}
+}
+
+func TestGetterPanic(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	var fixed int32
+	g := newGroup("TestGetterPanic-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		if atomic.LoadInt32(&fixed) == 0 {
+			close(started)
+			<-release
+			panic("getter bug")
+		}
+		return dest.SetString("got:" + key)
+	}), nil)
+
+	leaderPanic := make(chan interface{}, 1)
+	go func() {
+		defer func() { leaderPanic <- recover() }()
+		var s string
+		g.Get(dummyCtx, "key", StringSink(&s))
+	}()
+	<-started
+	waiterErr := make(chan error, 1)
+	go func() {
+		var s string
+		waiterErr <- g.Get(dummyCtx, "key", StringSink(&s))
+	}()
+	time.Sleep(50 * time.Millisecond) // let the second Get wait for the load
+	close(release)
+
+	if p, ok := (<-leaderPanic).(*singleflight.PanicError); !ok || p.Value != "getter bug" {
+		t.Errorf("Get panicked with %v; want a PanicError of the getter's panic", p)
+	}
+	if err := <-waiterErr; err == nil || !strings.Contains(err.Error(), "getter bug") {
+		t.Errorf("waiting Get error = %v; want the getter's panic", err)
+	}
+	if n := g.Stats.LoadPanics.Get(); n != 1 {
+		t.Errorf("LoadPanics = %d; want 1", n)
+	}
+
+	// Later Gets load the key again instead of hanging.
+	atomic.StoreInt32(&fixed, 1)
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != "got:key" {
+		t.Errorf("Get after panic = %q, %v", s, err)
+	}
+}
+
+func TestLoadsDeduped(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	g := newGroup("TestLoadsDeduped-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		close(started)
+		<-release
+		return dest.SetString("got:" + key)
+	}), nil)
+
+	const n = 3
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var s string
+			if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+				t.Error(err)
+			}
+		}()
+		if i == 0 {
+			<-started
+		}
+	}
+	time.Sleep(50 * time.Millisecond) // let the other Gets wait for the load
+	close(release)
+	wg.Wait()
+	if got := g.Stats.LocalLoads.Get(); got != 1 {
+		t.Errorf("LocalLoads = %d; want 1", got)
+	}
+	if got := g.Stats.LoadsDeduped.Get(); got != n-1 {
+		t.Errorf("LoadsDeduped = %d; want %d", got, n-1)
+	}
+}
+
+func TestPeerFallbacks(t *testing.T) {
+	owner := &fakePeer{fail: true}
+	next := &fakePeer{fail: true}
+	last := &fakePeer{}
+	var localLoads int
+	getter := GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		localLoads++
+		return dest.SetString("local:" + key)
+	})
+	peers := fallbackPeers{owner: owner, fallbacks: []ProtoGetter{next, last}}
+	g := DefaultRegistry.newGroup("TestPeerFallbacks-group", cacheSize, getter, peers, &GroupOptions{PeerFallbacks: 2})
+
+	var s string
+	if err := g.Get(dummyCtx, "a", StringSink(&s)); err != nil || s != "got:a" {
+		t.Fatalf("Get(a) = %q, %v; want %q", s, err, "got:a")
+	}
+	if owner.fallbacks != 0 || next.fallbacks != 1 || last.fallbacks != 1 {
+		t.Errorf("fallback requests = %d, %d, %d; want 0, 1, 1", owner.fallbacks, next.fallbacks, last.fallbacks)
+	}
+	if got := g.Stats.FallbackLoads.Get(); got != 1 {
+		t.Errorf("FallbackLoads = %d; want 1", got)
+	}
+	if got := g.Stats.PeerErrors.Get(); got != 2 {
+		t.Errorf("PeerErrors = %d; want 2", got)
+	}
+
+	// Keys that the owner fails to answer in a batch go to the
+	// fallbacks too.
+	var b string
+	if err := g.GetMulti(dummyCtx, []string{"b"}, []Sink{StringSink(&b)}); err != nil || b != "got:b" {
+		t.Fatalf("GetMulti(b) = %q, %v; want %q", b, err, "got:b")
+	}
+	if owner.batches != 1 || owner.fallbacks != 0 || last.fallbacks != 2 {
+		t.Errorf("owner batches = %d, fallbacks = %d, last fallbacks = %d; want 1, 0, 2", owner.batches, owner.fallbacks, last.fallbacks)
+	}
+
+	// Only PeerFallbacks peers are asked before loading locally.
+	g1 := DefaultRegistry.newGroup("TestPeerFallbacks-group1", cacheSize, getter, peers, &GroupOptions{PeerFallbacks: 1})
+	if err := g1.Get(dummyCtx, "c", StringSink(&s)); err != nil || s != "local:c" {
+		t.Fatalf("Get(c) = %q, %v; want %q", s, err, "local:c")
+	}
+	if next.fallbacks != 3 || last.fallbacks != 2 || localLoads != 1 {
+		t.Errorf("next fallbacks = %d, last fallbacks = %d, local loads = %d; want 3, 2, 1", next.fallbacks, last.fallbacks, localLoads)
+	}
+
+	// A fallback loads the key itself, without asking the owner.
+	owner.fail = false
+	hits := owner.hits
+	if err := g.getFallback(dummyCtx, "d", StringSink(&s)); err != nil || s != "local:d" {
+		t.Fatalf("getFallback(d) = %q, %v; want %q", s, err, "local:d")
+	}
+	if owner.hits != hits {
+		t.Error("fallback load asked the owner")
+	}
+}
+
+// A fakeClock is a manually advanced clock for nowFunc.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// useFakeClock makes nowFunc return the time of a fakeClock starting
+// at now, until the returned func is called.
+func useFakeClock(now time.Time) (c *fakeClock, restore func()) {
+	c = &fakeClock{now: now}
+	nowFunc = c.Now
+	return c, func() { nowFunc = time.Now }
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	c.now = now
+	c.mu.Unlock()
+}
+
+func TestExpiration(t *testing.T) {
+	clock, restore := useFakeClock(time.Unix(1000, 0))
+	defer restore()
+
+	fills := 0
+	g := newGroup("TestExpiration-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		fills++
+		dest.SetExpire(nowFunc().Add(time.Minute))
+		return dest.SetString(fmt.Sprintf("%s:%d", key, fills))
+	}), NoPeers{})
+
+	get := func() ByteView {
+		var v ByteView
+		if err := g.Get(dummyCtx, "key", ByteViewSink(&v)); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	v := get()
+	if want := clock.Now().Add(time.Minute); !v.Expire().Equal(want) {
+		t.Errorf("Expire = %v; want %v", v.Expire(), want)
+	}
+	clock.Advance(59 * time.Second)
+	if v := get(); v.String() != "key:1" {
+		t.Errorf("before expiry got %q; want %q", v, "key:1")
+	}
+	clock.Advance(time.Second)
+	if v := get(); v.String() != "key:2" {
+		t.Errorf("after expiry got %q; want %q", v, "key:2")
+	}
+	if fills != 2 {
+		t.Errorf("fills = %d; want 2", fills)
+	}
+	if items := g.mainCache.items(); items != 1 {
+		t.Errorf("mainCache has %d items; want 1", items)
+	}
+}
+
+// TestExpirationRace checks that a get finding an expired value
+// doesn't drop a value Set concurrently, nor count as an eviction.
+func TestExpirationRace(t *testing.T) {
+	_, restore := useFakeClock(time.Unix(1000, 0))
+	defer restore()
+	g := newGroup("TestExpirationRace-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return errors.New("unexpected load")
+	}), NoPeers{})
+
+	expired := ByteView{s: "old", e: time.Unix(999, 0)}
+	for i := 0; i < 1000; i++ {
+		g.mainCache.add("key", expired)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			g.mainCache.get("key")
+		}()
+		go func() {
+			defer wg.Done()
+			if err := g.Set(dummyCtx, "key", []byte("new"), time.Time{}, false); err != nil {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+		if v, ok := g.mainCache.get("key"); !ok || v.String() != "new" {
+			t.Fatalf("iteration %d: get = %q, %v; want the value just Set", i, v, ok)
+		}
+	}
+	if n := g.CacheStats(MainCache).Evictions; n != 0 {
+		t.Errorf("Evictions = %d; want 0", n)
+	}
+}
+
+type expiringPeer struct {
+	expire time.Time
+}
+
+func (p *expiringPeer) Get(_ context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	out.Value = []byte("got:" + in.GetKey())
+	out.Expire = proto.Int64(p.expire.UnixNano())
+	return nil
+}
+
+func (p *expiringPeer) Set(_ context.Context, in *pb.SetRequest, out *pb.SetResponse) error {
+	return nil
+}
+
+func (p *expiringPeer) Remove(_ context.Context, in *pb.RemoveRequest, out *pb.RemoveResponse) error {
+	return nil
+}
+
+func (p *expiringPeer) GetMulti(_ context.Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error {
+	return errors.New("unexpected batch")
+}
+
+func TestPeerExpiration(t *testing.T) {
+	clock, restore := useFakeClock(time.Unix(1000, 0))
+	defer restore()
+
+	peer := &expiringPeer{expire: clock.Now().Add(time.Minute)}
+	g := newGroup("TestPeerExpiration-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}), fakePeers{peer})
+	g.peersOnce.Do(g.initPeers)
+
+	value, err := g.getFromPeer(dummyCtx, peer, "key", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !value.Expire().Equal(peer.expire) {
+		t.Fatalf("Expire = %v; want the owner's %v", value.Expire(), peer.expire)
+	}
+	g.populateCache("key", value, &g.hotCache)
+	if _, ok := g.lookupCache("key"); !ok {
+		t.Fatal("hotCache miss before the owner's deadline")
+	}
+	clock.Set(peer.expire)
+	if _, ok := g.lookupCache("key"); ok {
+		t.Error("hotCache hit after the owner's deadline")
+	}
+
+	// Values that are already expired are never cached.
+	g.populateCache("key", value, &g.hotCache)
+	if items := g.hotCache.items(); items != 0 {
+		t.Errorf("hotCache has %d items; want 0", items)
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	clock, restore := useFakeClock(time.Unix(1000, 0))
+	defer restore()
+
+	errNotFound := errors.New("not found")
+	errTransient := errors.New("transient")
+	var loads int
+	var loadErr error
+	g := DefaultRegistry.newGroup("TestNegativeCaching-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		loads++
+		if loadErr != nil {
+			return loadErr
+		}
+		return dest.SetString("ok")
+	}), NoPeers{}, &GroupOptions{
+		ErrorTTL:    time.Minute,
+		MaxErrorTTL: 3 * time.Minute,
+		CacheError: func(key string, err error) bool {
+			return err != errTransient
+		},
+	})
+
+	get := func(key string) error {
+		var s string
+		return g.Get(dummyCtx, key, StringSink(&s))
+	}
+	check := func(name, key string, wantErr error, wantLoads int) {
+		t.Helper()
+		if err := get(key); err != wantErr {
+			t.Errorf("%s: Get error = %v; want %v", name, err, wantErr)
+		}
+		if loads != wantLoads {
+			t.Errorf("%s: loads = %d; want %d", name, loads, wantLoads)
+		}
+	}
+
+	loadErr = errNotFound
+	check("first failure", "k", errNotFound, 1)
+	check("cached failure", "k", errNotFound, 1)
+	if hits := g.Stats.ErrorHits.Get(); hits != 1 {
+		t.Errorf("ErrorHits = %d; want 1", hits)
+	}
+
+	// Consecutive failures back off: 1m, 2m, then capped at 3m.
+	clock.Advance(time.Minute)
+	check("second failure", "k", errNotFound, 2)
+	clock.Advance(time.Minute)
+	check("backed off", "k", errNotFound, 2)
+	clock.Advance(time.Minute)
+	check("third failure", "k", errNotFound, 3)
+	clock.Advance(3*time.Minute - time.Second)
+	check("capped backoff", "k", errNotFound, 3)
+	clock.Advance(time.Second)
+
+	// A success forgets the key's failures.
+	loadErr = nil
+	check("success", "k", nil, 4)
+	g.Remove(dummyCtx, "k")
+	loadErr = errNotFound
+	check("failure after success", "k", errNotFound, 5)
+	clock.Advance(time.Minute)
+	check("backoff reset", "k", errNotFound, 6)
+
+	// Errors rejected by CacheError are not cached.
+	loadErr = errTransient
+	check("transient", "t", errTransient, 7)
+	check("transient again", "t", errTransient, 8)
+}
+
+func TestNegativeCachingDefaults(t *testing.T) {
+	var loads int
+	g := DefaultRegistry.newGroup("TestNegativeCachingDefaults-group", cacheSize, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		// As if a call made by the getter timed out.
+		return context.DeadlineExceeded
+	}), NoPeers{}, &GroupOptions{ErrorTTL: time.Minute})
+	for i := 0; i < 2; i++ {
+		var s string
+		if err := g.Get(context.Background(), "k", StringSink(&s)); err != context.DeadlineExceeded {
+			t.Fatalf("Get error = %v; want %v", err, context.DeadlineExceeded)
+		}
+	}
+	if loads != 2 {
+		t.Errorf("loads = %d; want 2, as context errors aren't cached", loads)
+	}
+}
+
+// errorPeer is a peer whose Getter failed with a cached error.
+type errorPeer struct {
+	fakePeer
+	err    string
+	expire time.Time
+}
+
+func (p *errorPeer) Get(_ context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	p.hits++
+	out.Error = proto.String(p.err)
+	out.Expire = proto.Int64(p.expire.UnixNano())
+	return nil
+}
+
+func TestPeerNegativeCaching(t *testing.T) {
+	clock, restore := useFakeClock(time.Unix(1000, 0))
+	defer restore()
+
+	peer := &errorPeer{err: "owner: not found", expire: clock.Now().Add(time.Minute)}
+	g := newGroup("TestPeerNegativeCaching-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}), fakePeers{peer})
+
+	for i := 0; i < 2; i++ {
+		var s string
+		err := g.Get(dummyCtx, "k", StringSink(&s))
+		if err == nil || err.Error() != peer.err {
+			t.Fatalf("Get error = %v; want the owner's %q", err, peer.err)
+		}
+	}
+	if peer.hits != 1 {
+		t.Errorf("peer hits = %d; want 1", peer.hits)
+	}
+	if n := g.Stats.PeerErrors.Get(); n != 0 {
+		t.Errorf("PeerErrors = %d; want 0", n)
+	}
+
+	clock.Set(peer.expire)
+	var s string
+	g.Get(dummyCtx, "k", StringSink(&s))
+	if peer.hits != 2 {
+		t.Errorf("peer hits after the owner's deadline = %d; want 2", peer.hits)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	clock, restore := useFakeClock(time.Unix(1000, 0))
+	defer restore()
+
+	var (
+		mu      sync.Mutex
+		loads   int
+		version = "v1"
+		loadErr error
+	)
+	release := make(chan bool, 1)
+	release <- true
+	g := DefaultRegistry.newGroup("TestStaleWhileRevalidate-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		loads++
+		if loadErr != nil {
+			return loadErr
+		}
+		dest.SetExpire(nowFunc().Add(time.Minute))
+		return dest.SetString(version)
+	}), NoPeers{}, &GroupOptions{
+		ErrorTTL:             time.Second,
+		StaleWhileRevalidate: time.Hour,
+	})
+
+	get := func() (string, error) {
+		var s string
+		err := g.Get(dummyCtx, "k", StringSink(&s))
+		return s, err
+	}
+	// waitLoads waits for the background refresh to finish.
+	waitLoads := func(want int) {
+		t.Helper()
+		for i := 0; ; i++ {
+			mu.Lock()
+			n := loads
+			mu.Unlock()
+			g.refreshMu.Lock()
+			pending := g.refreshing["k"]
+			g.refreshMu.Unlock()
+			if n == want && !pending {
+				return
+			}
+			if i == 1000 {
+				t.Fatalf("loads = %d; want %d", n, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if s, err := get(); err != nil || s != "v1" {
+		t.Fatalf("first Get = %q, %v; want v1", s, err)
+	}
+
+	// Once expired, the old value is served right away while a
+	// single refresh runs in the background.
+	clock.Advance(time.Minute)
+	mu.Lock()
+	version = "v2"
+	mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if s, err := get(); err != nil || s != "v1" {
+			t.Fatalf("stale Get %d = %q, %v; want v1", i, s, err)
+		}
+	}
+	if hits := g.Stats.StaleHits.Get(); hits != 3 {
+		t.Errorf("StaleHits = %d; want 3", hits)
+	}
+	release <- true
+	waitLoads(2)
+	if s, err := get(); err != nil || s != "v2" {
+		t.Errorf("Get after refresh = %q, %v; want v2", s, err)
+	}
+
+	// A failed refresh keeps serving the stale value within the window.
+	clock.Advance(time.Minute)
+	mu.Lock()
+	loadErr = errors.New("backend down")
+	mu.Unlock()
+	release <- true
+	if s, err := get(); err != nil || s != "v2" {
+		t.Errorf("Get with failing refresh = %q, %v; want v2", s, err)
+	}
+	waitLoads(3)
+	if s, err := get(); err != nil || s != "v2" {
+		t.Errorf("Get after failed refresh = %q, %v; want v2", s, err)
+	}
+	waitLoads(3) // the failure is cached, so no new load
+
+	// Past the window the value is gone and loads are synchronous.
+	clock.Advance(time.Hour)
+	release <- true
+	if _, err := get(); err == nil {
+		t.Errorf("Get past the stale window succeeded; want error")
+	}
+	waitLoads(4)
+}
+
+func TestObserver(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	observer := ObserverFunc(func(e Event) {
+		if e.Group != "TestObserver-group" {
+			t.Errorf("event of group %q", e.Group)
+		}
+		s := e.Type.String() + " " + e.Key
+		switch e.Type {
+		case CacheHit, CacheMiss, Eviction:
+			s += " " + e.Cache.String()
+		case PeerFetchStart, PeerFetchDone:
+			s += " " + e.Peer
+		}
+		if e.Err != nil {
+			s += " err"
+		}
+		mu.Lock()
+		events = append(events, s)
+		mu.Unlock()
+	})
+	peer := &fakePeer{fail: true}
+	g := DefaultRegistry.newGroup("TestObserver-group", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("got:" + key)
+	}), fakePeers{peer}, &GroupOptions{Observer: observer})
+
+	var s string
+	for i := 0; i < 2; i++ {
+		if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"CacheMiss key main",
+		"CacheMiss key hot",
+		"PeerFetchStart key *groupcache.fakePeer",
+		"PeerFetchDone key *groupcache.fakePeer err",
+		"LocalLoadStart key",
+		"LocalLoadDone key",
+		"CacheHit key main",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %q; want %q", events, want)
+	}
+
+	// Filling the cache evicts the oldest key.
+	events = nil
+	g.cacheBytes = entrySize("other", ByteView{s: "got:key"})
+	g.populateCache("other", ByteView{s: "got:key"}, &g.mainCache)
+	want = []string{"Eviction key main"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %q; want %q", events, want)
+	}
+}
+
+func TestPeerErrors(t *testing.T) {
+	peer := &fakePeer{fail: true}
+	g := newGroup("TestPeerErrors-group", 0, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("got:" + key)
+	}), fakePeers{peer})
+
+	if errs := g.PeerErrors(); len(errs) != 0 {
+		t.Fatalf("PeerErrors = %v; want none", errs)
+	}
+	n := peerErrorLogSize + 3
+	for i := 0; i < n; i++ {
+		var s string
+		if err := g.Get(dummyCtx, fmt.Sprintf("key-%d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := g.PeerErrors()
+	if len(errs) != peerErrorLogSize {
+		t.Fatalf("got %d PeerErrors; want %d", len(errs), peerErrorLogSize)
+	}
+	for i, e := range errs {
+		if want := fmt.Sprintf("key-%d", n-peerErrorLogSize+i); e.Key != want {
+			t.Errorf("PeerErrors[%d].Key = %q; want %q", i, e.Key, want)
+		}
+		if e.Peer != "*groupcache.fakePeer" || e.Err == nil {
+			t.Errorf("PeerErrors[%d] = %+v", i, e)
+		}
 	}
 }
 
@@ -451,6 +1659,18 @@ func TestGroupStatsAlignment(t *testing.T) {
 	if off%8 != 0 {
 		t.Fatal("Stats structure is not 8-byte aligned.")
 	}
+	for name, off := range map[string]uintptr{
+		"localLatency": unsafe.Offsetof(g.localLatency),
+		"peerLatency":  unsafe.Offsetof(g.peerLatency),
+	} {
+		if off%8 != 0 {
+			t.Errorf("%s is not 8-byte aligned.", name)
+		}
+	}
+	// The counters are aligned if the structs holding them are.
+	if unsafe.Sizeof(Stats{})%8 != 0 || unsafe.Sizeof(latencyHistogram{})%8 != 0 || unsafe.Sizeof(PeerStats{})%8 != 0 {
+		t.Error("Stats, latencyHistogram or PeerStats size is not a multiple of 8.")
+	}
 }
 
 // TODO(bradfitz): port the Google-internal full integration test into here,
@@ -19,12 +19,16 @@ package groupcache
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/groupcache/consistenthash"
 	pb "github.com/golang/groupcache/groupcachepb"
@@ -35,6 +39,48 @@ const defaultBasePath = "/_groupcache/"
 
 const defaultReplicas = 50
 
+// timeoutHeader carries the time left before the requester's deadline,
+// in milliseconds.
+// 请求方剩余的超时时间（毫秒）
+const timeoutHeader = "X-Groupcache-Timeout-Ms"
+
+// fallbackHeader marks a Get sent to a fallback of the key's owner.
+// 标记发往 owner 接替者的 Get 请求
+const fallbackHeader = "X-Groupcache-Fallback"
+
+// errorHeader marks a 500 response to a Get whose body is a
+// GetResponse carrying the Getter's error. Peers that predate it only
+// see the status, and load the key themselves as they always did.
+// 标记携带 Getter 错误的 500 响应，旧版本的 peer 只看到状态码
+const errorHeader = "X-Groupcache-Error"
+
+// opsPath prefixes the paths of the operations other than Get, as in
+// BasePath + "_ops/set/" + group + "/" + key. Peers that predate these
+// operations take "_ops" for a group name and reject the request,
+// instead of serving it as a Get.
+// Get 以外的操作使用单独的路径，旧版本的 peer 会将 "_ops" 当作 group 名并拒绝请求
+const opsPath = "_ops/"
+
+// opHeader echoes the operation in the responses to requests under
+// opsPath. A response without it comes from a peer that doesn't know
+// the operation.
+// 响应中回显操作名，缺少该响应头说明对端不支持此操作
+const opHeader = "X-Groupcache-Op"
+
+// maxRequestBytes bounds the bodies of the requests an HTTPPool
+// reads, that is Set's value and GetMulti's keys. Larger requests are
+// rejected with 413 Request Entity Too Large.
+// 请求体大小上限，超出时返回 413
+const maxRequestBytes = 64 << 20
+
+// opMethods maps the operations under opsPath to their HTTP methods.
+// 操作名与请求方法的对应关系
+var opMethods = map[string]string{
+	"set":      http.MethodPut,
+	"remove":   http.MethodDelete,
+	"getmulti": http.MethodPost,
+}
+
 // HTTPPool implements PeerPicker for a pool of HTTP peers.
 // 实现 PeerPicker 的 http 池
 type HTTPPool struct {
@@ -58,13 +104,29 @@ type HTTPPool struct {
 	// 指定的选项
 	opts HTTPPoolOptions
 
+	// registry holds the groups served by the pool.
+	// 本池服务的 group 所在的 Registry
+	registry *Registry
+
+	// handler serves requests through opts.Interceptors.
+	// 经过拦截器链的请求处理函数
+	handler ServerHandler
+
 	// 保护peer和httpGetters
 	mu          sync.Mutex // guards peers and httpGetters
-	// 一致性哈希
-	peers       *consistenthash.Map
+	// 一致性哈希或 opts.NewPlacement 创建的其他放置算法
+	peers       consistenthash.Placement
 	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
 }
 
+// PeerStats are the counters of the requests an HTTPPool sent to one
+// of its peers.
+// 发往单个 peer 的请求计数
+type PeerStats struct {
+	Requests AtomicInt // requests sent to the peer
+	Errors   AtomicInt // requests that failed, including non-OK responses
+}
+
 // HTTPPoolOptions are the configurations of a HTTPPool.
 // HTTPPool 的配置
 type HTTPPoolOptions struct {
@@ -82,6 +144,17 @@ type HTTPPoolOptions struct {
 	// If blank, it defaults to crc32.ChecksumIEEE.
 	// 指定一致哈希的哈希函数，如果为空，则默认为crc32。ChecksumIEEE
 	HashFn consistenthash.Hash
+
+	// NewPlacement optionally creates the placement of keys on the
+	// pool's peers, such as consistenthash.NewRendezvous(nil),
+	// instead of the consistent hash ring of Replicas and HashFn.
+	// 可选：创建 key 在 peer 上的放置算法，替代默认的一致性哈希环
+	NewPlacement func() consistenthash.Placement
+
+	// Interceptors wrap the handling of received requests, the first
+	// being the outermost. They run before the group is looked up.
+	// 包裹收到的请求的拦截器，第一个在最外层，在查找 group 之前执行
+	Interceptors []ServerInterceptor
 }
 
 // NewHTTPPool initializes an HTTP pool of peers, and registers itself as a PeerPicker.
@@ -96,22 +169,31 @@ func NewHTTPPool(self string) *HTTPPool {
 	return p
 }
 
-// 标志 NewHTTPPool 是否被调用
-var httpPoolMade bool
-
 // NewHTTPPoolOpts initializes an HTTP pool of peers with the given options.
 // Unlike NewHTTPPool, this function does not register the created pool as an HTTP handler.
 // The returned *HTTPPool implements http.Handler and must be registered using http.Handle.
 // NewHTTPPoolOpts使用给定选项初始化HTTP对等体池。与NewHTTPPool不同，此函数不会将创建的池注册为HTTP处理程序。
 // 返回的 *HTTPPool 实现了 http.Handler 并且必须使用 http.Handle 注册
+//
+// The pool serves and picks peers for the groups of DefaultRegistry.
 func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
-	if httpPoolMade {
+	return DefaultRegistry.NewHTTPPoolOpts(self, o)
+}
+
+// NewHTTPPoolOpts initializes an HTTP pool of peers for the groups of r,
+// and registers it as r's PeerPicker. It may be called only once per
+// Registry. Like the package-level NewHTTPPoolOpts, it does not
+// register the pool as an HTTP handler.
+// 为 r 中的 group 初始化 HTTP 对等池，并注册为 r 的 PeerPicker；每个 Registry 只能调用一次
+func (r *Registry) NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
+	if r.httpPoolMade {
 		panic("groupcache: NewHTTPPool must be called only once")
 	}
-	httpPoolMade = true
+	r.httpPoolMade = true
 
 	p := &HTTPPool{
 		self:        self,
+		registry:    r,
 		httpGetters: make(map[string]*httpGetter),
 	}
 	// 判断是否传入 否则使用默认
@@ -124,10 +206,15 @@ func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
 	if p.opts.Replicas == 0 {
 		p.opts.Replicas = defaultReplicas
 	}
-	// 初始化一致性哈希环
-	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	// 初始化一致性哈希环或指定的放置算法
+	if p.opts.NewPlacement != nil {
+		p.peers = p.opts.NewPlacement()
+	} else {
+		p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	}
+	p.handler = interceptServer(p.serve, p.opts.Interceptors)
 	// 注册peer
-	RegisterPeerPicker(func() PeerPicker { return p })
+	r.RegisterPeerPicker(func() PeerPicker { return p })
 	// 返回 httpPool
 	return p
 }
@@ -137,17 +224,54 @@ func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
 // for example "http://example.net:8000".
 // 更新池的peer列表。 每个peer应该是一个有效的基本 URL，例如“http://example.net:8000”。
 func (p *HTTPPool) Set(peers ...string) {
+	weights := make(map[string]float64, len(peers))
+	for _, peer := range peers {
+		weights[peer] = 1
+	}
+	p.SetWeighted(weights)
+}
+
+// SetWeighted is like Set, but gives each peer a share of the keys in
+// proportion to its weight, such as its capacity. A peer of weight 2
+// has twice the replicas of a peer of weight 1 on the consistent hash;
+// a peer with a zero weight owns no keys.
+//
+// Only the peers that are added, removed or reweighted change on the
+// consistent hash, so that only the keys they own move. Placements
+// made by HTTPPoolOptions.NewPlacement move keys as their Set does.
+// 按权重更新 peer 列表，只增删变化的 peer，其余 peer 的虚拟节点保持不变
+func (p *HTTPPool) SetWeighted(peers map[string]float64) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	// 创建缓冲池
-	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
-	// 将服务器添加到缓存池
-	p.peers.Add(peers...)
-	//
-	p.httpGetters = make(map[string]*httpGetter, len(peers))
-	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{transport: p.Transport, baseURL: peer + p.opts.BasePath}
+	p.peers.Set(peers)
+	// 删除不在列表中的 peer
+	for peer := range p.httpGetters {
+		if _, ok := peers[peer]; !ok {
+			delete(p.httpGetters, peer)
+		}
 	}
+	// 添加新的 peer；仍在列表中的 peer 保留其请求计数
+	for peer := range peers {
+		if _, ok := p.httpGetters[peer]; !ok {
+			p.httpGetters[peer] = &httpGetter{transport: p.Transport, baseURL: peer + p.opts.BasePath, stats: new(PeerStats)}
+		}
+	}
+}
+
+// PeerStats returns the request counters of the pool's current peers,
+// keyed by their base URL as given to Set. Counters of a peer are kept
+// as long as it remains in the pool.
+// 返回当前各 peer 的请求计数，以 Set 传入的 URL 为键
+func (p *HTTPPool) PeerStats() map[string]*PeerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make(map[string]*PeerStats, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			stats[peer] = getter.stats
+		}
+	}
+	return stats
 }
 
 // 根据 key 选择 peer
@@ -163,6 +287,40 @@ func (p *HTTPPool) PickPeer(key string) (ProtoGetter, bool) {
 	return nil, false
 }
 
+// PickFallbacks returns up to n peers that follow key's owner in the
+// key's order of preference, stopping before this one.
+// 按 key 的优先顺序返回 owner 之后的 peer，遇到自身即停止
+func (p *HTTPPool) PickFallbacks(key string, n int) []ProtoGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	nodes := p.peers.GetN(key, n+1)
+	if len(nodes) == 0 || nodes[0] == p.self {
+		return nil
+	}
+	var peers []ProtoGetter
+	for _, node := range nodes[1:] {
+		if node == p.self {
+			break
+		}
+		peers = append(peers, p.httpGetters[node])
+	}
+	return peers
+}
+
+// ListPeers returns the getters of all peers other than this one.
+// 返回除自身以外所有 peer 的 getter
+func (p *HTTPPool) ListPeers() []ProtoGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]ProtoGetter, 0, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
 // 获取对应url 的 response
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Parse request.
@@ -171,7 +329,21 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(r.URL.Path, p.opts.BasePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
-	parts := strings.SplitN(r.URL.Path[len(p.opts.BasePath):], "/", 2)
+	path := r.URL.Path[len(p.opts.BasePath):]
+	if strings.HasPrefix(path, opsPath) {
+		// Get 以外的操作：校验操作名与请求方法是否匹配
+		parts := strings.SplitN(path[len(opsPath):], "/", 2)
+		if len(parts) != 2 || opMethods[parts[0]] != r.Method {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set(opHeader, parts[0])
+		path = parts[1]
+	} else if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.SplitN(path, "/", 2)
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
@@ -180,13 +352,6 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	groupName := parts[0]
 	key := parts[1]
 
-	// Fetch the value for this group/key.
-	// 获取 group 值
-	group := GetGroup(groupName)
-	if group == nil {
-		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
-		return
-	}
 	// 关联上下文
 	var ctx context.Context
 	if p.Context != nil {
@@ -194,34 +359,213 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	} else {
 		ctx = r.Context()
 	}
+	// 请求方超时或断开时取消处理
+	ctx, cancel := p.requestContext(ctx, r)
+	defer cancel()
+	// 延续请求方的链路追踪上下文
+	ctx = extractTrace(ctx, r.Header)
 
+	// 经过拦截器链后处理请求
+	p.handler(ctx, groupName, key, w, r)
+}
+
+// requestContext returns ctx, the context of the handling of r,
+// bounded by the deadline r carries and cancelled when r is.
+// 为 ctx 加上请求携带的超时时间，并在请求被取消时一并取消
+func (p *HTTPPool) requestContext(ctx context.Context, r *http.Request) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+	if d, ok := parseTimeout(r.Header.Get(timeoutHeader)); ok {
+		ctx, cancel = context.WithTimeout(ctx, d)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	// p.Context 返回的上下文可能与请求无关，需要监听请求的取消
+	if p.Context != nil {
+		go func() {
+			select {
+			case <-r.Context().Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+// formatTimeout formats d for timeoutHeader, rounding up so that a
+// pending deadline is never sent as zero.
+// 按毫秒向上取整
+func formatTimeout(d time.Duration) string {
+	ms := (d + time.Millisecond - 1) / time.Millisecond
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(int64(ms), 10)
+}
+
+// parseTimeout parses the value of timeoutHeader.
+// 解析 timeoutHeader，非法或非正数时返回 false
+func parseTimeout(s string) (time.Duration, bool) {
+	if s == "" {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ms <= 0 || ms > int64(math.MaxInt64/time.Millisecond) {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// 查找 group 并按请求方法分发
+func (p *HTTPPool) serve(ctx context.Context, groupName, key string, w http.ResponseWriter, r *http.Request) {
+	// Fetch the value for this group/key.
+	// 获取 group 值
+	group := p.registry.GetGroup(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+
+	// 根据请求方法分发：GET 获取值，POST 批量获取，PUT 写入值，DELETE 删除 key
+	switch r.Method {
+	case http.MethodPost:
+		p.serveGetMulti(ctx, w, r, group)
+	case http.MethodPut:
+		p.serveSet(w, r, group, key)
+	case http.MethodDelete:
+		p.serveRemove(ctx, w, group, key)
+	default:
+		p.serveGet(ctx, w, group, key, r.Header.Get(fallbackHeader) != "")
+	}
+}
+
+// 获取单个 key；fallback 为真时请求方无法访问 owner，由本节点直接加载
+func (p *HTTPPool) serveGet(ctx context.Context, w http.ResponseWriter, group *Group, key string, fallback bool) {
 	// 请求计数
 	group.Stats.ServerRequests.Add(1)
-	var value []byte
-	err := group.Get(ctx, key, AllocatingByteSliceSink(&value))
+	var value ByteView
+	var res *pb.GetResponse
+	var err error
+	if fallback {
+		err = group.getFallback(ctx, key, ByteViewSink(&value))
+	} else {
+		err = group.Get(ctx, key, ByteViewSink(&value))
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// 请求方已超时或取消，该错误不是加载的结果
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// 将 Getter 的错误返回给请求方，使其不再向其他 peer 或本地重复加载；
+		// 错误已被缓存（负缓存）时附带过期时间，使其在过期前不再重复加载
+		res = &pb.GetResponse{Error: proto.String(err.Error())}
+		if cerr, expire, ok := group.cachedError(key); ok {
+			res.Error = proto.String(cerr.Error())
+			res.Expire = proto.Int64(expire.UnixNano())
+		}
+	} else {
+		// Write the value to the response body as a proto message.
+		// 将该值作为原始消息写入响应正文
+		// 附带该 key 的请求速率，供请求方决定是否放入 hotCache
+		res = &pb.GetResponse{
+			Value:     value.ByteSlice(),
+			MinuteQps: proto.Float64(group.keyQPS(key)),
+		}
+		if e := value.Expire(); !e.IsZero() {
+			// 将过期时间一并返回，让请求方遵守 owner 的过期时间
+			res.Expire = proto.Int64(e.UnixNano())
+		}
+	}
+	body, err := proto.Marshal(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// 拼head 以及 body
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	if res.Error != nil {
+		// 错误响应保持非 2xx 状态码，避免旧版本的 peer 将其当作空值
+		w.Header().Set(errorHeader, "1")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write(body)
+}
 
-	// Write the value to the response body as a proto message.
-	// 将该值作为原始消息写入响应正文
-	body, err := proto.Marshal(&pb.GetResponse{Value: value})
+// 批量获取：请求体中的 key 均属于本 group，逐个返回结果
+func (p *HTTPPool) serveGetMulti(ctx context.Context, w http.ResponseWriter, r *http.Request, group *Group) {
+	var req pb.GetMultiRequest
+	if !readRequest(w, r, &req) {
+		return
+	}
+	group.Stats.ServerRequests.Add(1)
+	res := group.serveGetMulti(ctx, req.GetKeys())
+	if err := ctx.Err(); err != nil {
+		// 请求方已超时或取消，各 key 的错误不是加载的结果
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err := proto.Marshal(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// 拼head 以及 body
 	w.Header().Set("Content-Type", "application/x-protobuf")
 	w.Write(body)
 }
 
+// 将请求体中的值写入 mainCache
+func (p *HTTPPool) serveSet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	var req pb.SetRequest
+	if !readRequest(w, r, &req) {
+		return
+	}
+	value := ByteView{b: req.GetValue()}
+	if req.Expire != nil {
+		value.e = time.Unix(0, req.GetExpire())
+	}
+	group.serveSet(key, value)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+}
+
+// 删除 key：本地驱逐，若本节点是 owner 还会通知其余 peer
+func (p *HTTPPool) serveRemove(ctx context.Context, w http.ResponseWriter, group *Group, key string) {
+	if err := group.serveRemove(ctx, key); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+}
+
+// 读取并解码请求体，失败时写入 400（超过 maxRequestBytes 时为 413）响应并返回 false
+func readRequest(w http.ResponseWriter, r *http.Request, req proto.Message) bool {
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	defer bufferPool.Put(b)
+	if _, err := io.Copy(b, http.MaxBytesReader(w, r.Body, maxRequestBytes)); err != nil {
+		code := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, "reading request body: "+err.Error(), code)
+		return false
+	}
+	if err := proto.Unmarshal(b.Bytes(), req); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
 //
 type httpGetter struct {
 	// 链路
 	transport func(context.Context) http.RoundTripper
 	// 基础 URL
 	baseURL   string
+	// 请求计数
+	stats     *PeerStats
 }
 
 // 内存池
@@ -229,22 +573,84 @@ var bufferPool = sync.Pool{
 	New: func() interface{} { return new(bytes.Buffer) },
 }
 
+// 以 baseURL 作为 peer 的名字，用于观测事件与错误记录
+func (h *httpGetter) String() string { return h.baseURL }
+
 // 从url链路获取数据，并写入pb 数据结构中
 func (h *httpGetter) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	var header http.Header
+	if in.GetFallback() {
+		header = http.Header{fallbackHeader: {"1"}}
+	}
+	return h.makeRequest(ctx, "", in.GetGroup(), in.GetKey(), header, nil, out)
+}
+
+// 将值写入对端的 mainCache
+func (h *httpGetter) Set(ctx context.Context, in *pb.SetRequest, out *pb.SetResponse) error {
+	return h.makeRequest(ctx, "set", in.GetGroup(), in.GetKey(), nil, in, out)
+}
+
+// 通知对端删除 key
+func (h *httpGetter) Remove(ctx context.Context, in *pb.RemoveRequest, out *pb.RemoveResponse) error {
+	return h.makeRequest(ctx, "remove", in.GetGroup(), in.GetKey(), nil, nil, out)
+}
+
+// 一次请求批量获取多个 key，key 放在请求体中
+func (h *httpGetter) GetMulti(ctx context.Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error {
+	return h.makeRequest(ctx, "getmulti", in.GetGroup(), "", nil, in, out)
+}
+
+// 向 group/key 对应的 url 发送 op 操作的请求（op 为空时为 Get），附带 header 中的请求头，in 不为空时作为请求体，并将响应写入 out
+func (h *httpGetter) makeRequest(ctx context.Context, op, group, key string, header http.Header, in, out proto.Message) error {
+	// 统计请求数与失败数
+	if h.stats == nil {
+		return h.roundTrip(ctx, op, group, key, header, in, out)
+	}
+	h.stats.Requests.Add(1)
+	err := h.roundTrip(ctx, op, group, key, header, in, out)
+	if err != nil {
+		h.stats.Errors.Add(1)
+	}
+	return err
+}
+
+// 发送请求并解码响应
+func (h *httpGetter) roundTrip(ctx context.Context, op, group, key string, header http.Header, in, out proto.Message) error {
 	// 拼装完整链路
+	base, method := h.baseURL, http.MethodGet
+	if op != "" {
+		base, method = h.baseURL+opsPath+op+"/", opMethods[op]
+	}
 	u := fmt.Sprintf(
 		"%v%v/%v",
-		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
+		base,
+		url.QueryEscape(group),
+		url.QueryEscape(key),
 	)
+	var body io.Reader
+	if in != nil {
+		b, err := proto.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
 	// 建立请求
-	req, err := http.NewRequest("GET", u, nil)
+	req, err := http.NewRequest(method, u, body)
 	if err != nil {
 		return err
 	}
 	// 初始化请求参数
 	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	// 传递链路追踪上下文
+	injectTrace(ctx, req.Header)
+	// 将剩余的超时时间告知对端
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(timeoutHeader, formatTimeout(time.Until(deadline)))
+	}
 	tr := http.DefaultTransport
 	if h.transport != nil {
 		tr = h.transport(ctx)
@@ -255,8 +661,12 @@ func (h *httpGetter) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResp
 		return err
 	}
 	defer res.Body.Close()
-	// 查看响应状态码
-	if res.StatusCode != http.StatusOK {
+	// 对端未回显操作名，说明其版本早于该操作
+	if op != "" && res.Header.Get(opHeader) != op {
+		return fmt.Errorf("%w: %s (server returned: %v)", ErrPeerUnsupported, op, res.Status)
+	}
+	// 查看响应状态码，携带 errorHeader 的响应体中是 Getter 的错误
+	if res.StatusCode != http.StatusOK && res.Header.Get(errorHeader) == "" {
 		return fmt.Errorf("server returned: %v", res.Status)
 	}
 	// 获取响应数据
@@ -17,12 +17,15 @@ limitations under the License.
 package groupcache
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"strconv"
@@ -30,6 +33,10 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/golang/groupcache/consistenthash"
+	pb "github.com/golang/groupcache/groupcachepb"
+	"github.com/golang/protobuf/proto"
 )
 
 var (
@@ -103,6 +110,585 @@ func TestHTTPPool(t *testing.T) {
 	}
 }
 
+func TestHTTPPoolExpire(t *testing.T) {
+	expire := time.Now().Add(time.Hour).Round(0)
+	r := NewRegistry()
+	r.newGroup("httpPoolExpireTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		dest.SetExpire(expire)
+		return dest.SetString("value:" + key)
+	}), NoPeers{}, nil)
+
+	_, srv := startTestPool(r)
+	defer srv.Close()
+
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	req := &pb.GetRequest{Group: proto.String("httpPoolExpireTest"), Key: proto.String("k")}
+	res := &pb.GetResponse{}
+	if err := h.Get(context.TODO(), req, res); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(res.GetValue()); got != "value:k" {
+		t.Errorf("value = %q; want %q", got, "value:k")
+	}
+	if got := time.Unix(0, res.GetExpire()); !got.Equal(expire) {
+		t.Errorf("expire = %v; want %v", got, expire)
+	}
+}
+
+func TestHTTPPoolMinuteQPS(t *testing.T) {
+	r := NewRegistry()
+	r.newGroup("httpPoolQPSTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), NoPeers{}, nil)
+
+	_, srv := startTestPool(r)
+	defer srv.Close()
+
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	req := &pb.GetRequest{Group: proto.String("httpPoolQPSTest"), Key: proto.String("k")}
+	res := &pb.GetResponse{}
+	for i := 0; i < 3; i++ {
+		if err := h.Get(context.TODO(), req, res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := res.GetMinuteQps(), 3/rateWindow.Seconds(); got != want {
+		t.Errorf("minute_qps = %v; want %v", got, want)
+	}
+}
+
+func TestHTTPPoolCachedError(t *testing.T) {
+	r := NewRegistry()
+	r.newGroup("httpPoolErrorTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return errors.New("not found: " + key)
+	}), NoPeers{}, &GroupOptions{ErrorTTL: time.Hour})
+
+	_, srv := startTestPool(r)
+	defer srv.Close()
+
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	req := &pb.GetRequest{Group: proto.String("httpPoolErrorTest"), Key: proto.String("k")}
+	res := &pb.GetResponse{}
+	if err := h.Get(context.TODO(), req, res); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.GetError(), "not found: k"; got != want {
+		t.Errorf("error = %q; want %q", got, want)
+	}
+	if exp := time.Unix(0, res.GetExpire()); !exp.After(time.Now()) {
+		t.Errorf("expire = %v; want a time in the future", exp)
+	}
+}
+
+func TestHTTPPoolRemove(t *testing.T) {
+	peer := &fakePeer{}
+	r := NewRegistry()
+	g := r.newGroup("httpPoolRemoveTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), fakePeers{nil, peer}, nil)
+	key := keyOwnedBy(fakePeers{nil, peer}, 0)
+	var s string
+	if err := g.Get(context.TODO(), key, StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, srv := startTestPool(r)
+	defer srv.Close()
+
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	req := &pb.RemoveRequest{Group: proto.String("httpPoolRemoveTest"), Key: proto.String(key)}
+	if err := h.Remove(context.TODO(), req, &pb.RemoveResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.lookupCache(key); ok {
+		t.Error("key still cached after remote Remove")
+	}
+	if peer.removes != 1 {
+		t.Errorf("peer got %d removes; want 1 from the owner's fan-out", peer.removes)
+	}
+}
+
+func TestHTTPPoolSet(t *testing.T) {
+	r := NewRegistry()
+	g := r.newGroup("httpPoolSetTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}), NoPeers{}, nil)
+
+	_, srv := startTestPool(r)
+	defer srv.Close()
+
+	expire := time.Now().Add(time.Hour).Round(0)
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	req := &pb.SetRequest{
+		Group:  proto.String("httpPoolSetTest"),
+		Key:    proto.String("k"),
+		Value:  []byte("pushed"),
+		Expire: proto.Int64(expire.UnixNano()),
+	}
+	if err := h.Set(context.TODO(), req, &pb.SetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	var v ByteView
+	if err := g.Get(context.TODO(), "k", ByteViewSink(&v)); err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "pushed" {
+		t.Errorf("Get = %q; want %q", v, "pushed")
+	}
+	if !v.Expire().Equal(expire) {
+		t.Errorf("Expire = %v; want %v", v.Expire(), expire)
+	}
+}
+
+// TestHTTPPoolOldPeer checks that Set, Remove and GetMulti sent to a
+// peer that predates them fail instead of being served as Gets.
+func TestHTTPPoolOldPeer(t *testing.T) {
+	// Like the old HTTPPool, answer every request as a Get.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := proto.Marshal(&pb.GetResponse{Value: []byte("got")})
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	ctx := context.TODO()
+	group, key := proto.String("g"), proto.String("k")
+	if err := h.Set(ctx, &pb.SetRequest{Group: group, Key: key, Value: []byte("v")}, &pb.SetResponse{}); !errors.Is(err, ErrPeerUnsupported) {
+		t.Errorf("Set error = %v; want ErrPeerUnsupported", err)
+	}
+	if err := h.Remove(ctx, &pb.RemoveRequest{Group: group, Key: key}, &pb.RemoveResponse{}); !errors.Is(err, ErrPeerUnsupported) {
+		t.Errorf("Remove error = %v; want ErrPeerUnsupported", err)
+	}
+	if err := h.GetMulti(ctx, &pb.GetMultiRequest{Group: group, Keys: []string{"k"}}, &pb.GetMultiResponse{}); !errors.Is(err, ErrPeerUnsupported) {
+		t.Errorf("GetMulti error = %v; want ErrPeerUnsupported", err)
+	}
+
+	// The new pool doesn't serve other methods as Gets either.
+	_, pool := startTestPool(NewRegistry())
+	defer pool.Close()
+	req, _ := http.NewRequest(http.MethodPut, pool.URL+defaultBasePath+"g/k", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("PUT to the Get path = %v; want %d", res.Status, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestReadRequestLimit(t *testing.T) {
+	body := make([]byte, maxRequestBytes+1)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, defaultBasePath+opsPath+"set/g/k", bytes.NewReader(body))
+	if readRequest(w, r, &pb.SetRequest{}) {
+		t.Fatal("readRequest accepted a body over maxRequestBytes")
+	}
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d; want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+// TestHTTPPoolRegistries runs two peers in this process, each with
+// its own Registry and HTTPPool.
+func TestHTTPPoolRegistries(t *testing.T) {
+	const nPeers = 2
+	var (
+		pools []*HTTPPool
+		urls  []string
+		regs  []*Registry
+	)
+	for i := 0; i < nPeers; i++ {
+		r := NewRegistry()
+		p, srv := startTestPool(r)
+		defer srv.Close()
+		i := i
+		r.NewGroup("registryTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			return dest.SetString(strconv.Itoa(i) + ":" + key)
+		}))
+		regs = append(regs, r)
+		pools = append(pools, p)
+		urls = append(urls, srv.URL)
+	}
+	for _, p := range pools {
+		p.Set(urls...)
+	}
+
+	owners := make(map[string]bool)
+	for _, key := range testKeys(20) {
+		var values [nPeers]string
+		for i, r := range regs {
+			if err := r.GetGroup("registryTest").Get(context.TODO(), key, StringSink(&values[i])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if values[0] != values[1] {
+			t.Errorf("Get(%q) = %q from peer 0 but %q from peer 1; want the owner's value from both", key, values[0], values[1])
+		}
+		owners[strings.SplitN(values[0], ":", 2)[0]] = true
+	}
+	if len(owners) != nPeers {
+		t.Errorf("keys were loaded by %d peers; want %d", len(owners), nPeers)
+	}
+}
+
+func TestHTTPPoolGetMulti(t *testing.T) {
+	const nPeers = 2
+	var (
+		pools []*HTTPPool
+		urls  []string
+		regs  []*Registry
+	)
+	for i := 0; i < nPeers; i++ {
+		r := NewRegistry()
+		p, srv := startTestPool(r)
+		defer srv.Close()
+		i := i
+		r.NewGroup("getMultiTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			if key == "bad" {
+				return errors.New("bad key")
+			}
+			return dest.SetString(strconv.Itoa(i) + ":" + key)
+		}))
+		regs = append(regs, r)
+		pools = append(pools, p)
+		urls = append(urls, srv.URL)
+	}
+	for _, p := range pools {
+		p.Set(urls...)
+	}
+
+	keys := append(testKeys(20), "bad")
+	values := make([]string, len(keys))
+	dests := make([]Sink, len(keys))
+	for i := range keys {
+		dests[i] = StringSink(&values[i])
+	}
+	g0, g1 := regs[0].GetGroup("getMultiTest"), regs[1].GetGroup("getMultiTest")
+	err := g0.GetMulti(context.TODO(), keys, dests)
+	merr, ok := err.(MultiError)
+	if !ok {
+		t.Fatalf("GetMulti error = %v; want a MultiError", err)
+	}
+	for i, key := range keys {
+		if key == "bad" {
+			if merr[i] == nil {
+				t.Errorf("GetMulti(%q) succeeded; want error", key)
+			}
+			continue
+		}
+		if merr[i] != nil {
+			t.Errorf("GetMulti(%q) error = %v", key, merr[i])
+			continue
+		}
+		var want string
+		if err := g1.Get(context.TODO(), key, StringSink(&want)); err != nil {
+			t.Fatal(err)
+		}
+		if values[i] != want {
+			t.Errorf("GetMulti(%q) = %q; want the owner's value %q", key, values[i], want)
+		}
+	}
+	if got := g1.Stats.ServerRequests.Get(); got != 1 {
+		t.Errorf("peer 1 served %d requests; want a single batch", got)
+	}
+}
+
+// startTestPool starts an HTTP server for a new HTTPPool of r, whose
+// self URL is the server's.
+func TestHTTPPoolPeerStats(t *testing.T) {
+	r := NewRegistry()
+	r.newGroup("httpPoolPeerStatsTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), NoPeers{}, nil)
+	_, srv := startTestPool(r)
+	defer srv.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	const self = "http://self.invalid"
+	p := NewRegistry().NewHTTPPoolOpts(self, nil)
+	p.Set(self, srv.URL, dead.URL)
+	req := &pb.GetRequest{Group: proto.String("httpPoolPeerStatsTest"), Key: proto.String("k")}
+	for i := 0; i < 3; i++ {
+		if err := p.httpGetters[srv.URL].Get(context.TODO(), req, &pb.GetResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.httpGetters[dead.URL].Get(context.TODO(), req, &pb.GetResponse{}); err == nil {
+		t.Fatal("Get from closed server succeeded")
+	}
+
+	check := func(stats map[string]*PeerStats) {
+		t.Helper()
+		if len(stats) != 2 {
+			t.Fatalf("PeerStats has %d peers; want 2 (self excluded)", len(stats))
+		}
+		if got := stats[srv.URL]; got.Requests.Get() != 3 || got.Errors.Get() != 0 {
+			t.Errorf("live peer: requests = %v, errors = %v; want 3, 0", &got.Requests, &got.Errors)
+		}
+		if got := stats[dead.URL]; got.Requests.Get() != 1 || got.Errors.Get() != 1 {
+			t.Errorf("dead peer: requests = %v, errors = %v; want 1, 1", &got.Requests, &got.Errors)
+		}
+	}
+	check(p.PeerStats())
+
+	// Counters survive updates of the peer list.
+	p.Set(self, srv.URL, dead.URL)
+	check(p.PeerStats())
+}
+
+func TestHTTPPoolSetWeighted(t *testing.T) {
+	p := NewRegistry().NewHTTPPoolOpts("http://self", nil)
+	p.Set("http://self", "http://a", "http://b", "http://c")
+	stats := p.PeerStats()["http://a"]
+	p.SetWeighted(map[string]float64{"http://self": 1, "http://a": 1, "http://c": 2, "http://d": 1})
+
+	// The pool matches one built from scratch with the same peers.
+	fresh := NewRegistry().NewHTTPPoolOpts("http://self", nil)
+	fresh.SetWeighted(map[string]float64{"http://self": 1, "http://a": 1, "http://c": 2, "http://d": 1})
+	for _, key := range testKeys(1000) {
+		got, gotOK := p.PickPeer(key)
+		want, wantOK := fresh.PickPeer(key)
+		if gotOK != wantOK || gotOK && got.(*httpGetter).baseURL != want.(*httpGetter).baseURL {
+			t.Fatalf("PickPeer(%q) = %v, %v; want %v, %v", key, got, gotOK, want, wantOK)
+		}
+	}
+
+	peers := p.PeerStats()
+	if len(peers) != 3 || peers["http://b"] != nil {
+		t.Errorf("peers = %v; want a, c and d", peers)
+	}
+	if peers["http://a"] != stats {
+		t.Error("the counters of a peer that stayed were reset")
+	}
+}
+
+func TestHTTPPoolPlacement(t *testing.T) {
+	p := NewRegistry().NewHTTPPoolOpts("http://self", &HTTPPoolOptions{
+		NewPlacement: func() consistenthash.Placement { return consistenthash.NewMaglev(0, nil) },
+	})
+	p.Set("http://self", "http://a", "http://b")
+	want := consistenthash.NewMaglev(0, nil)
+	want.Set(map[string]float64{"http://self": 1, "http://a": 1, "http://b": 1})
+	for _, key := range testKeys(1000) {
+		peer, ok := p.PickPeer(key)
+		owner := want.Get(key)
+		if ok != (owner != "http://self") || ok && peer.(*httpGetter).baseURL != owner+defaultBasePath {
+			t.Fatalf("PickPeer(%q) = %v, %v; want %q", key, peer, ok, owner)
+		}
+		if fb := p.PickFallbacks(key, 2); ok && len(fb) == 0 && want.GetN(key, 2)[1] != "http://self" {
+			t.Fatalf("PickFallbacks(%q) = %v; want a fallback", key, fb)
+		}
+	}
+}
+
+func TestHTTPPoolDeadline(t *testing.T) {
+	r := NewRegistry()
+	deadlines := make(chan time.Duration, 1)
+	r.newGroup("httpPoolDeadlineTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadlines <- 0
+		} else {
+			deadlines <- time.Until(deadline)
+		}
+		return dest.SetString("value:" + key)
+	}), NoPeers{}, nil)
+
+	p, srv := startTestPool(r)
+	defer srv.Close()
+	// The server's own context knows nothing of the requester.
+	p.Context = func(*http.Request) context.Context { return context.Background() }
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	req := &pb.GetRequest{Group: proto.String("httpPoolDeadlineTest"), Key: proto.String("k")}
+	if err := h.Get(ctx, req, &pb.GetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if d := <-deadlines; d <= 50*time.Second || d > time.Minute {
+		t.Errorf("getter's time left = %v; want about a minute", d)
+	}
+}
+
+func TestHTTPPoolCancel(t *testing.T) {
+	r := NewRegistry()
+	started, cancelled := make(chan bool), make(chan error, 1)
+	r.newGroup("httpPoolCancelTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		close(started)
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return ctx.Err()
+	}), NoPeers{}, nil)
+
+	p, srv := startTestPool(r)
+	defer srv.Close()
+	p.Context = func(*http.Request) context.Context { return context.Background() }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	req := &pb.GetRequest{Group: proto.String("httpPoolCancelTest"), Key: proto.String("k")}
+	errc := make(chan error, 1)
+	go func() { errc <- h.Get(ctx, req, &pb.GetResponse{}) }()
+	<-started
+	cancel()
+	if err := <-errc; err == nil {
+		t.Error("cancelled Get succeeded")
+	}
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Errorf("getter's context error = %v; want %v", err, context.Canceled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("getter still running after the requester cancelled")
+	}
+}
+
+func TestHTTPPoolFallback(t *testing.T) {
+	r := NewRegistry()
+	var loads AtomicInt
+	r.newGroup("httpPoolFallbackTest", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		loads.Add(1)
+		return dest.SetString("value:" + key)
+	}), nil, nil)
+
+	p, srv := startTestPool(r)
+	defer srv.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	p.Set(srv.URL, dead.URL)
+
+	key := ""
+	for _, k := range testKeys(100) {
+		if _, ok := p.PickPeer(k); ok {
+			key = k
+			break
+		}
+	}
+	if key == "" {
+		t.Fatal("no key owned by the other peer")
+	}
+	// This pool is the only peer after the owner.
+	if fb := p.PickFallbacks(key, 2); len(fb) != 0 {
+		t.Errorf("PickFallbacks(%q) = %v; want none", key, fb)
+	}
+
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	req := &pb.GetRequest{Group: proto.String("httpPoolFallbackTest"), Key: proto.String(key), Fallback: proto.Bool(true)}
+	res := &pb.GetResponse{}
+	if err := h.Get(context.Background(), req, res); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(res.GetValue()), "value:"+key; got != want {
+		t.Errorf("value = %q; want %q", got, want)
+	}
+	if loads.Get() != 1 {
+		t.Errorf("loads = %d; want 1", loads.Get())
+	}
+	if n := p.PeerStats()[dead.URL].Requests.Get(); n != 0 {
+		t.Errorf("requests to the owner = %d; want 0", n)
+	}
+}
+
+func TestHTTPPoolGetterErrorIsFinal(t *testing.T) {
+	// Each process is a registry with its own pool and a Getter that
+	// counts its calls; the owner's fails without caching the error.
+	var ownerLoads, fallbackLoads, localLoads AtomicInt
+	newPeer := func(name string, loads *AtomicInt, fail bool) *httptest.Server {
+		r := NewRegistry()
+		r.newGroup("httpPoolGetterErrorTest", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+			loads.Add(1)
+			if fail {
+				return errors.New("backend down")
+			}
+			return dest.SetString(name + ":" + key)
+		}), NoPeers{}, nil)
+		_, srv := startTestPool(r)
+		return srv
+	}
+	owner := newPeer("owner", &ownerLoads, true)
+	defer owner.Close()
+	fallback := newPeer("fallback", &fallbackLoads, false)
+	defer fallback.Close()
+
+	g := NewRegistry().newGroup("httpPoolGetterErrorTest", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		localLoads.Add(1)
+		return dest.SetString("local:" + key)
+	}), fallbackPeers{
+		owner:     &httpGetter{baseURL: owner.URL + defaultBasePath},
+		fallbacks: []ProtoGetter{&httpGetter{baseURL: fallback.URL + defaultBasePath}},
+	}, &GroupOptions{PeerFallbacks: 1})
+
+	var s string
+	if err := g.Get(context.Background(), "k", StringSink(&s)); err == nil || err.Error() != "backend down" {
+		t.Errorf("Get error = %v; want the owner's Getter error", err)
+	}
+	var a, b string
+	if err := g.GetMulti(context.Background(), []string{"a", "b"}, []Sink{StringSink(&a), StringSink(&b)}); err == nil {
+		t.Error("GetMulti succeeded; want the owner's Getter errors")
+	}
+	if ownerLoads.Get() != 3 || fallbackLoads.Get() != 0 || localLoads.Get() != 0 {
+		t.Errorf("Getter calls: owner %d, fallback %d, local %d; want 3, 0, 0", ownerLoads.Get(), fallbackLoads.Get(), localLoads.Get())
+	}
+	if g.Stats.PeerErrors.Get() != 0 {
+		t.Errorf("PeerErrors = %d; want 0", g.Stats.PeerErrors.Get())
+	}
+}
+
+// TestHTTPPoolGetterErrorOldClient checks that a Getter error isn't
+// taken for an empty value by a peer that predates GetResponse.error.
+func TestHTTPPoolGetterErrorOldClient(t *testing.T) {
+	r := NewRegistry()
+	for _, ttl := range []time.Duration{0, time.Hour} {
+		r.newGroup(fmt.Sprintf("httpPoolOldClientTest-%v", ttl), 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			return errors.New("not found: " + key)
+		}), NoPeers{}, &GroupOptions{ErrorTTL: ttl})
+	}
+
+	_, srv := startTestPool(r)
+	defer srv.Close()
+
+	// Like the old httpGetter, only read Value from 200 responses.
+	for _, ttl := range []time.Duration{0, time.Hour} {
+		for i := 0; i < 2; i++ {
+			res, err := http.Get(fmt.Sprintf("%s%shttpPoolOldClientTest-%v/k", srv.URL, defaultBasePath, ttl))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				t.Errorf("ErrorTTL %v, Get %d: status = %v; want an error status", ttl, i, res.Status)
+			}
+		}
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	for _, d := range []time.Duration{time.Nanosecond, time.Millisecond, 1500 * time.Microsecond, time.Hour} {
+		got, ok := parseTimeout(formatTimeout(d))
+		if !ok || got < d || got > d+time.Millisecond {
+			t.Errorf("timeout %v sent as %q, parsed as %v, %v", d, formatTimeout(d), got, ok)
+		}
+	}
+	for _, s := range []string{"", "0", "-5", "1.5", "soon", "99999999999999999999"} {
+		if d, ok := parseTimeout(s); ok {
+			t.Errorf("parseTimeout(%q) = %v; want failure", s, d)
+		}
+	}
+}
+
+func startTestPool(r *Registry) (*HTTPPool, *httptest.Server) {
+	var p *HTTPPool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p.ServeHTTP(w, req)
+	}))
+	p = r.NewHTTPPoolOpts(srv.URL, nil)
+	return p, srv
+}
+
 func testKeys(n int) (keys []string) {
 	keys = make([]string, n)
 	for i := range keys {
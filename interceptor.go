@@ -0,0 +1,90 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"net/http"
+
+	pb "github.com/golang/groupcache/groupcachepb"
+)
+
+// A GetterInterceptor wraps the loads of a group from its Getter, for
+// example to check permissions, apply a timeout or retry. It is
+// called with the group's name and the key to load, and loads it by
+// calling next, which it may also skip to answer by itself.
+//
+// See GroupOptions.GetterInterceptors.
+type GetterInterceptor func(ctx context.Context, group, key string, dest Sink, next Getter) error
+
+// A PeerGetFunc gets a value from a peer, like ProtoGetter.Get.
+type PeerGetFunc func(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error
+
+// A PeerInterceptor wraps the requests of a group to peers. It is
+// called with the request, whose group and key are in.GetGroup() and
+// in.GetKey(), and sends it to peer by calling next, which it may
+// also skip to fill out or fail by itself.
+//
+// See GroupOptions.PeerInterceptors.
+type PeerInterceptor func(ctx context.Context, peer ProtoGetter, in *pb.GetRequest, out *pb.GetResponse, next PeerGetFunc) error
+
+// A ServerHandler handles a request received by an HTTPPool for key
+// of the named group. The key is empty for batch requests.
+type ServerHandler func(ctx context.Context, group, key string, w http.ResponseWriter, r *http.Request)
+
+// A ServerInterceptor wraps the requests received by an HTTPPool. It
+// hands the request on by calling next, or answers it by itself by
+// writing to w, for example to deny it.
+//
+// See HTTPPoolOptions.Interceptors.
+type ServerInterceptor func(ctx context.Context, group, key string, w http.ResponseWriter, r *http.Request, next ServerHandler)
+
+// interceptGetter returns getter wrapped by interceptors, the first
+// of which is the outermost.
+func interceptGetter(group string, getter Getter, interceptors []GetterInterceptor) Getter {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, next := interceptors[i], getter
+		getter = GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			return ic(ctx, group, key, dest, next)
+		})
+	}
+	return getter
+}
+
+// peerGet sends in to peer through g's peer interceptors.
+func (g *Group) peerGet(ctx context.Context, peer ProtoGetter, in *pb.GetRequest, out *pb.GetResponse) error {
+	call := PeerGetFunc(peer.Get)
+	for i := len(g.opts.PeerInterceptors) - 1; i >= 0; i-- {
+		ic, next := g.opts.PeerInterceptors[i], call
+		call = func(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+			return ic(ctx, peer, in, out, next)
+		}
+	}
+	return call(ctx, in, out)
+}
+
+// interceptServer returns h wrapped by interceptors, the first of
+// which is the outermost.
+func interceptServer(h ServerHandler, interceptors []ServerInterceptor) ServerHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, next := interceptors[i], h
+		h = func(ctx context.Context, group, key string, w http.ResponseWriter, r *http.Request) {
+			ic(ctx, group, key, w, r, next)
+		}
+	}
+	return h
+}
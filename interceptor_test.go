@@ -0,0 +1,171 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	pb "github.com/golang/groupcache/groupcachepb"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestGetterInterceptors(t *testing.T) {
+	var calls []string
+	trace := func(name string) GetterInterceptor {
+		return func(ctx context.Context, group, key string, dest Sink, next Getter) error {
+			calls = append(calls, name+">"+group+"/"+key)
+			err := next.Get(ctx, key, dest)
+			calls = append(calls, "<"+name)
+			return err
+		}
+	}
+	errDenied := errors.New("denied")
+	deny := func(ctx context.Context, group, key string, dest Sink, next Getter) error {
+		if strings.HasPrefix(key, "secret") {
+			return errDenied
+		}
+		return next.Get(ctx, key, dest)
+	}
+	g := DefaultRegistry.newGroup("TestGetterInterceptors-group", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		calls = append(calls, "getter")
+		return dest.SetString("got:" + key)
+	}), NoPeers{}, &GroupOptions{GetterInterceptors: []GetterInterceptor{trace("a"), trace("b"), deny}})
+
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != "got:key" {
+		t.Fatalf("Get = %q, %v", s, err)
+	}
+	want := []string{"a>TestGetterInterceptors-group/key", "b>TestGetterInterceptors-group/key", "getter", "<b", "<a"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q; want %q", calls, want)
+	}
+
+	calls = nil
+	if err := g.Get(dummyCtx, "secret", StringSink(&s)); err != errDenied {
+		t.Errorf("Get(secret) error = %v; want %v", err, errDenied)
+	}
+	for _, c := range calls {
+		if c == "getter" {
+			t.Error("short-circuited load reached the getter")
+		}
+	}
+}
+
+func TestPeerInterceptors(t *testing.T) {
+	peer := &fakePeer{}
+	var (
+		mu   sync.Mutex // GetMulti fetches keys concurrently
+		seen []string
+	)
+	g := DefaultRegistry.newGroup("TestPeerInterceptors-group", 1<<20, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}), fakePeers{peer}, &GroupOptions{PeerInterceptors: []PeerInterceptor{
+		func(ctx context.Context, p ProtoGetter, in *pb.GetRequest, out *pb.GetResponse, next PeerGetFunc) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if p != peer {
+				t.Errorf("interceptor got peer %v; want %v", p, peer)
+			}
+			seen = append(seen, in.GetGroup()+"/"+in.GetKey())
+			if in.GetKey() == "canned" {
+				out.Value = []byte("from interceptor")
+				return nil
+			}
+			return next(ctx, in, out)
+		},
+	}})
+
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != "got:key" {
+		t.Fatalf("Get(key) = %q, %v", s, err)
+	}
+	if err := g.Get(dummyCtx, "canned", StringSink(&s)); err != nil || s != "from interceptor" {
+		t.Fatalf("Get(canned) = %q, %v", s, err)
+	}
+	if peer.hits != 1 {
+		t.Errorf("peer hits = %d; want 1", peer.hits)
+	}
+
+	// GetMulti requests keys one by one so the interceptors see them.
+	var a, b string
+	if err := g.GetMulti(dummyCtx, []string{"a", "b"}, []Sink{StringSink(&a), StringSink(&b)}); err != nil {
+		t.Fatal(err)
+	}
+	if peer.batches != 0 || peer.hits != 3 {
+		t.Errorf("peer batches = %d, hits = %d; want 0, 3", peer.batches, peer.hits)
+	}
+	if len(seen) != 4 {
+		t.Errorf("interceptor saw %q; want 4 requests", seen)
+	}
+}
+
+func TestHTTPPoolInterceptors(t *testing.T) {
+	r := NewRegistry()
+	r.newGroup("httpPoolInterceptorsTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		if v := ctx.Value(interceptorKey{}); v != nil {
+			return dest.SetString(v.(string) + ":" + key)
+		}
+		return dest.SetString("value:" + key)
+	}), NoPeers{}, nil)
+
+	var p *HTTPPool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+	var seen []string
+	p = r.NewHTTPPoolOpts(srv.URL, &HTTPPoolOptions{Interceptors: []ServerInterceptor{
+		func(ctx context.Context, group, key string, w http.ResponseWriter, r *http.Request, next ServerHandler) {
+			seen = append(seen, group+"/"+key)
+			next(context.WithValue(ctx, interceptorKey{}, "intercepted"), group, key, w, r)
+		},
+		func(ctx context.Context, group, key string, w http.ResponseWriter, r *http.Request, next ServerHandler) {
+			if key == "secret" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next(ctx, group, key, w, r)
+		},
+	}})
+
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	res := &pb.GetResponse{}
+	req := &pb.GetRequest{Group: proto.String("httpPoolInterceptorsTest"), Key: proto.String("k")}
+	if err := h.Get(context.TODO(), req, res); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(res.GetValue()); got != "intercepted:k" {
+		t.Errorf("value = %q; want %q", got, "intercepted:k")
+	}
+	req.Key = proto.String("secret")
+	if err := h.Get(context.TODO(), req, &pb.GetResponse{}); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Get(secret) error = %v; want 403", err)
+	}
+	want := []string{"httpPoolInterceptorsTest/k", "httpPoolInterceptorsTest/secret"}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("interceptor saw %q; want %q", seen, want)
+	}
+}
+
+type interceptorKey struct{}
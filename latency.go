@@ -0,0 +1,104 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sort"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the buckets of latency
+// Histograms.
+var latencyBuckets = [...]time.Duration{
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// A Histogram is a distribution of durations, as returned by
+// Group.LatencyStats.
+type Histogram struct {
+	// Bounds are the inclusive upper bounds of the buckets, in
+	// increasing order. The last bucket, past Bounds, is unbounded.
+	Bounds []time.Duration
+
+	// Counts are the number of durations in each bucket, so
+	// len(Counts) == len(Bounds)+1.
+	Counts []int64
+
+	// Sum is the sum of all durations.
+	Sum time.Duration
+}
+
+// Count returns the number of durations in h.
+func (h Histogram) Count() int64 {
+	var n int64
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+// LatencyStats are histograms of the latency of a group's loads, as
+// returned by Group.LatencyStats.
+type LatencyStats struct {
+	LocalLoads Histogram // calls of the Getter
+	PeerLoads  Histogram // requests to peers, including failed and batch ones
+}
+
+// LatencyStats returns histograms of the latency of g's loads.
+func (g *Group) LatencyStats() LatencyStats {
+	return LatencyStats{
+		LocalLoads: g.localLatency.histogram(),
+		PeerLoads:  g.peerLatency.histogram(),
+	}
+}
+
+// latencyHistogram counts durations in the latencyBuckets.
+type latencyHistogram struct {
+	counts [len(latencyBuckets) + 1]AtomicInt
+	sum    AtomicInt
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *latencyHistogram) histogram() Histogram {
+	hist := Histogram{
+		Bounds: append([]time.Duration(nil), latencyBuckets[:]...),
+		Counts: make([]int64, len(latencyBuckets)+1),
+		Sum:    time.Duration(h.sum.Get()),
+	}
+	for i := range hist.Counts {
+		hist.Counts[i] = h.counts[i].Get()
+	}
+	return hist
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// NewLFU returns an EvictionPolicy that evicts the least frequently
+// used entry, and the least recently used one among entries used
+// equally often. An entry's count starts over when it is evicted.
+func NewLFU() EvictionPolicy {
+	return &lfuPolicy{items: make(map[string]*lfuEntry)}
+}
+
+type lfuPolicy struct {
+	items map[string]*lfuEntry
+	heap  lfuHeap
+	clock uint64 // ticks on each access, to order entries by recency
+}
+
+type lfuEntry struct {
+	key   string
+	value ByteView
+	freq  uint64
+	used  uint64 // clock of the last access
+	index int    // in heap
+}
+
+func (p *lfuPolicy) touch(e *lfuEntry) {
+	p.clock++
+	e.freq++
+	e.used = p.clock
+	heap.Fix(&p.heap, e.index)
+}
+
+func (p *lfuPolicy) Add(key string, value ByteView) (old ByteView, replaced bool) {
+	if e, ok := p.items[key]; ok {
+		old, e.value = e.value, value
+		p.touch(e)
+		return old, true
+	}
+	p.clock++
+	e := &lfuEntry{key: key, value: value, freq: 1, used: p.clock}
+	p.items[key] = e
+	heap.Push(&p.heap, e)
+	return
+}
+
+func (p *lfuPolicy) Get(key string) (value ByteView, ok bool) {
+	e, ok := p.items[key]
+	if !ok {
+		return
+	}
+	p.touch(e)
+	return e.value, true
+}
+
+func (p *lfuPolicy) Remove(key string) (value ByteView, ok bool) {
+	e, ok := p.items[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&p.heap, e.index)
+	delete(p.items, key)
+	return e.value, true
+}
+
+func (p *lfuPolicy) Evict() (key string, value ByteView, ok bool) {
+	if len(p.heap) == 0 {
+		return
+	}
+	e := heap.Pop(&p.heap).(*lfuEntry)
+	delete(p.items, e.key)
+	return e.key, e.value, true
+}
+
+func (p *lfuPolicy) Len() int { return len(p.items) }
+
+func (p *lfuPolicy) Range(fn func(key string, value ByteView) bool) {
+	sorted := append(lfuHeap(nil), p.heap...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted.Less(i, j) })
+	for _, e := range sorted {
+		if !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// lfuHeap is a min-heap of entries ordered by frequency, then recency.
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].used < h[j].used
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
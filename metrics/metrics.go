@@ -0,0 +1,228 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics exports the stats of groupcache groups in the
+// OpenMetrics text format, for scraping by Prometheus.
+// 以 OpenMetrics 文本格式导出 groupcache 的统计数据，供 Prometheus 抓取
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/groupcache"
+)
+
+// ContentType is the content type of the OpenMetrics text format.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// An Exporter is an http.Handler serving the stats of every group of
+// a Registry, and optionally the per-peer counters of an HTTPPool.
+// Groups are listed on each scrape, so groups created after the
+// Exporter are exported too.
+// 导出 Registry 中所有 group 的统计数据，以及可选的 HTTPPool 各 peer 的请求计数
+type Exporter struct {
+	// Registry holds the exported groups.
+	// If nil, groupcache.DefaultRegistry is used.
+	Registry *groupcache.Registry
+
+	// Pool optionally specifies the HTTPPool whose per-peer counters
+	// are exported.
+	Pool *groupcache.HTTPPool
+}
+
+// New returns an Exporter of the groups of r and the peers of pool,
+// either of which may be nil.
+func New(r *groupcache.Registry, pool *groupcache.HTTPPool) *Exporter {
+	return &Exporter{Registry: r, Pool: pool}
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	e.WriteTo(w)
+}
+
+// counters are the exported Stats counters.
+var counters = []struct {
+	name, help string
+	get        func(*groupcache.Stats) *groupcache.AtomicInt
+}{
+	{"gets", "Get requests, including from peers.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.Gets }},
+	{"cache_hits", "Gets answered from a cache.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.CacheHits }},
+	{"peer_loads", "Loads answered by a peer.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.PeerLoads }},
+	{"peer_errors", "Loads from a peer that failed.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.PeerErrors }},
+	{"fallback_loads", "Loads answered by a fallback of the key's unreachable owner.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.FallbackLoads }},
+	{"loads", "Gets that missed the caches.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.Loads }},
+	{"loads_deduped", "Loads answered by a concurrent load of the same key.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.LoadsDeduped }},
+	{"local_loads", "Successful loads from the Getter.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.LocalLoads }},
+	{"local_load_errors", "Failed loads from the Getter.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.LocalLoadErrs }},
+	{"load_panics", "Loads whose Getter or peer request panicked.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.LoadPanics }},
+	{"server_requests", "Requests received from peers.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.ServerRequests }},
+	{"error_hits", "Gets answered with a cached load error.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.ErrorHits }},
+	{"stale_hits", "Cache hits served stale while revalidating.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.StaleHits }},
+	{"disk_errors", "Failed reads and writes of the disk tier.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.DiskErrors }},
+	{"disk_drops", "Main cache evictions not written to the disk tier because its writer was behind.", func(s *groupcache.Stats) *groupcache.AtomicInt { return &s.DiskDrops }},
+}
+
+// cacheMetrics are the exported CacheStats.
+var cacheMetrics = []struct {
+	name, typ, help string
+	get             func(groupcache.CacheStats) int64
+}{
+	{"cache_bytes", "gauge", "Bytes held by the cache.", func(s groupcache.CacheStats) int64 { return s.Bytes }},
+	{"cache_target_bytes", "gauge", "Bytes the cache may use.", func(s groupcache.CacheStats) int64 { return s.TargetBytes }},
+	{"cache_items", "gauge", "Items held by the cache.", func(s groupcache.CacheStats) int64 { return s.Items }},
+	{"cache_lookups", "counter", "Lookups in the cache.", func(s groupcache.CacheStats) int64 { return s.Gets }},
+	{"cache_lookup_hits", "counter", "Lookups that hit the cache.", func(s groupcache.CacheStats) int64 { return s.Hits }},
+	{"cache_evictions", "counter", "Items evicted from the cache.", func(s groupcache.CacheStats) int64 { return s.Evictions }},
+}
+
+var cacheTypes = []groupcache.CacheType{groupcache.MainCache, groupcache.HotCache}
+
+// WriteTo writes the metrics to w in the OpenMetrics text format.
+// 以 OpenMetrics 文本格式写出所有指标
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	r := e.Registry
+	if r == nil {
+		r = groupcache.DefaultRegistry
+	}
+	groups := r.Groups()
+	mw := &writer{w: bufio.NewWriter(w)}
+
+	for _, c := range counters {
+		mw.family(c.name, "counter", c.help)
+		for _, g := range groups {
+			mw.sample(c.name+"_total", c.get(&g.Stats).Get(), "group", g.Name())
+		}
+	}
+
+	for _, m := range cacheMetrics {
+		mw.family(m.name, m.typ, m.help)
+		suffix := ""
+		if m.typ == "counter" {
+			suffix = "_total"
+		}
+		for _, g := range groups {
+			for _, ct := range cacheTypes {
+				mw.sample(m.name+suffix, m.get(g.CacheStats(ct)), "group", g.Name(), "cache", ct.String())
+			}
+		}
+	}
+
+	mw.family("load_duration_seconds", "histogram", "Latency of loads from the Getter (source=local) and from peers (source=peer).")
+	for _, g := range groups {
+		st := g.LatencyStats()
+		mw.histogram("load_duration_seconds", st.LocalLoads, "group", g.Name(), "source", "local")
+		mw.histogram("load_duration_seconds", st.PeerLoads, "group", g.Name(), "source", "peer")
+	}
+
+	if e.Pool != nil {
+		stats := e.Pool.PeerStats()
+		peers := make([]string, 0, len(stats))
+		for peer := range stats {
+			peers = append(peers, peer)
+		}
+		sort.Strings(peers)
+		mw.family("peer_requests", "counter", "Requests sent to a peer.")
+		for _, peer := range peers {
+			mw.sample("peer_requests_total", stats[peer].Requests.Get(), "peer", peer)
+		}
+		mw.family("peer_request_errors", "counter", "Requests sent to a peer that failed.")
+		for _, peer := range peers {
+			mw.sample("peer_request_errors_total", stats[peer].Errors.Get(), "peer", peer)
+		}
+	}
+
+	mw.printf("# EOF\n")
+	if mw.err == nil {
+		mw.err = mw.w.Flush()
+	}
+	return mw.n, mw.err
+}
+
+// namespace prefixes the names of all metrics.
+const namespace = "groupcache_"
+
+// writer writes metrics, remembering the first error.
+type writer struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (mw *writer) printf(format string, args ...interface{}) {
+	if mw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(mw.w, format, args...)
+	mw.n += int64(n)
+	mw.err = err
+}
+
+// family writes the metadata of a metric family.
+func (mw *writer) family(name, typ, help string) {
+	mw.printf("# TYPE %s%s %s\n", namespace, name, typ)
+	mw.printf("# HELP %s%s %s\n", namespace, name, escape(help))
+}
+
+// sample writes a sample with the given label names and values.
+func (mw *writer) sample(name string, value int64, labels ...string) {
+	mw.printf("%s%s%s %d\n", namespace, name, formatLabels(labels), value)
+}
+
+// histogram writes the samples of h, converting durations to seconds.
+func (mw *writer) histogram(name string, h groupcache.Histogram, labels ...string) {
+	var count int64
+	for i, c := range h.Counts {
+		count += c
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = strconv.FormatFloat(h.Bounds[i].Seconds(), 'g', -1, 64)
+		}
+		mw.printf("%s%s_bucket%s %d\n", namespace, name, formatLabels(append(labels[:len(labels):len(labels)], "le", le)), count)
+	}
+	mw.printf("%s%s_sum%s %s\n", namespace, name, formatLabels(labels), strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64))
+	mw.printf("%s%s_count%s %d\n", namespace, name, formatLabels(labels), count)
+}
+
+// formatLabels formats pairs of label names and values as a label set.
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", labels[i], escape(labels[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escape escapes s for use in a label value or HELP text.
+func escape(s string) string {
+	return escaper.Replace(s)
+}
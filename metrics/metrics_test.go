@@ -0,0 +1,90 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang/groupcache"
+)
+
+func TestExporter(t *testing.T) {
+	r := groupcache.NewRegistry()
+	g := r.NewGroup(`we"ird`, 1<<20, groupcache.GetterFunc(func(_ context.Context, key string, dest groupcache.Sink) error {
+		return dest.SetString("value:" + key)
+	}))
+	pool := r.NewHTTPPoolOpts("http://self", nil)
+	var s string
+	for i := 0; i < 3; i++ {
+		if err := g.Get(context.TODO(), "k", groupcache.StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.Set("http://self", "http://peer:8000")
+
+	rec := httptest.NewRecorder()
+	New(r, pool).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q; want %q", ct, ContentType)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE groupcache_gets counter\n",
+		`groupcache_gets_total{group="we\"ird"} 3` + "\n",
+		`groupcache_cache_hits_total{group="we\"ird"} 2` + "\n",
+		`groupcache_local_loads_total{group="we\"ird"} 1` + "\n",
+		`groupcache_load_panics_total{group="we\"ird"} 0` + "\n",
+		"# TYPE groupcache_cache_items gauge\n",
+		`groupcache_cache_items{group="we\"ird",cache="main"} 1` + "\n",
+		`groupcache_cache_items{group="we\"ird",cache="hot"} 0` + "\n",
+		"# TYPE groupcache_load_duration_seconds histogram\n",
+		`groupcache_load_duration_seconds_bucket{group="we\"ird",source="local",le="+Inf"} 1` + "\n",
+		`groupcache_load_duration_seconds_count{group="we\"ird",source="local"} 1` + "\n",
+		`groupcache_load_duration_seconds_count{group="we\"ird",source="peer"} 0` + "\n",
+		`groupcache_peer_requests_total{peer="http://peer:8000"} 0` + "\n",
+		`groupcache_peer_request_errors_total{peer="http://peer:8000"} 0` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q", want)
+		}
+	}
+	if strings.Contains(out, `peer="http://self"`) {
+		t.Error("output has counters of self")
+	}
+	if !strings.HasSuffix(out, "\n# EOF\n") {
+		t.Error("output doesn't end with # EOF")
+	}
+
+	// Histogram buckets are cumulative.
+	last := int64(0)
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, `groupcache_load_duration_seconds_bucket{group="we\"ird",source="local"`) {
+			n, err := strconv.ParseInt(line[strings.LastIndex(line, " ")+1:], 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n < last {
+				t.Errorf("bucket %q below previous count %d", line, last)
+			}
+			last = n
+		}
+	}
+}
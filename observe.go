@@ -0,0 +1,205 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// An EventType is the type of an Event.
+type EventType int
+
+const (
+	// CacheHit is a lookup of the key that hit Event.Cache.
+	CacheHit EventType = iota + 1
+
+	// CacheMiss is a lookup of the key that missed Event.Cache.
+	// A lookup missing every cache has a CacheMiss for each.
+	CacheMiss
+
+	// PeerFetchStart is the start of a request to Event.Peer.
+	PeerFetchStart
+
+	// PeerFetchDone is the end of a request to Event.Peer, which
+	// failed if Event.Err is set and took Event.Latency.
+	PeerFetchDone
+
+	// LocalLoadStart is the start of a call of the Getter.
+	LocalLoadStart
+
+	// LocalLoadDone is the end of a call of the Getter, which
+	// failed if Event.Err is set and took Event.Latency.
+	LocalLoadDone
+
+	// LoadDeduped is a load of the key that waited for the result
+	// of another load of the key already in flight, instead of
+	// loading it again.
+	LoadDeduped
+
+	// Eviction is the eviction of the key from Event.Cache to make
+	// room for other keys.
+	Eviction
+)
+
+var eventTypeNames = map[EventType]string{
+	CacheHit:       "CacheHit",
+	CacheMiss:      "CacheMiss",
+	PeerFetchStart: "PeerFetchStart",
+	PeerFetchDone:  "PeerFetchDone",
+	LocalLoadStart: "LocalLoadStart",
+	LocalLoadDone:  "LocalLoadDone",
+	LoadDeduped:    "LoadDeduped",
+	Eviction:       "Eviction",
+}
+
+func (t EventType) String() string {
+	if s, ok := eventTypeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// An Event is something that happened in a Group, reported to its
+// Observer.
+type Event struct {
+	Type  EventType
+	Group string
+	Key   string // empty for batch requests to peers
+
+	// Cache is the cache of CacheHit, CacheMiss and Eviction events.
+	Cache CacheType
+
+	// Peer names the peer of PeerFetchStart and PeerFetchDone
+	// events: the String of the ProtoGetter if it has one, such as
+	// the base URL of HTTPPool peers.
+	Peer string
+
+	// Batch is the number of keys of a batch request to a peer, as
+	// sent by GetMulti, or zero.
+	Batch int
+
+	Err     error         // of done events
+	Latency time.Duration // of done events
+}
+
+// An Observer is notified of the Events of a Group. See
+// GroupOptions.Observer.
+//
+// Observe is called synchronously, from the goroutines using the
+// group, so it must be fast and safe for concurrent use.
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc implements Observer with a function.
+type ObserverFunc func(e Event)
+
+func (f ObserverFunc) Observe(e Event) { f(e) }
+
+// observe reports e to g's observer, if any.
+func (g *Group) observe(e Event) {
+	if g.opts.Observer == nil {
+		return
+	}
+	e.Group = g.name
+	g.opts.Observer.Observe(e)
+}
+
+// observeLookup reports a lookup of key that hit the cache which, or
+// missed every cache if hit is false.
+func (g *Group) observeLookup(key string, which CacheType, hit bool) {
+	if g.opts.Observer == nil {
+		return
+	}
+	caches := []CacheType{MainCache, HotCache}
+	if g.disk != nil {
+		caches = append(caches, DiskCache)
+	}
+	for _, ct := range caches {
+		if hit && ct == which {
+			g.observe(Event{Type: CacheHit, Key: key, Cache: ct})
+			return
+		}
+		g.observe(Event{Type: CacheMiss, Key: key, Cache: ct})
+	}
+}
+
+// peerName returns the name of peer for Events and PeerErrors.
+func peerName(peer ProtoGetter) string {
+	if s, ok := peer.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", peer)
+}
+
+// peerErrorLogSize is the number of errors kept by a peerErrorLog.
+const peerErrorLogSize = 32
+
+// A PeerError is a failed request to a peer, as returned by
+// Group.PeerErrors.
+type PeerError struct {
+	Time time.Time
+	Peer string
+	Key  string // empty for batch requests
+	Err  error
+}
+
+// peerErrorLog is a ring buffer of the most recent PeerErrors.
+type peerErrorLog struct {
+	mu   sync.Mutex
+	errs [peerErrorLogSize]PeerError
+	n    int // number of errors ever added
+}
+
+func (l *peerErrorLog) add(peer ProtoGetter, key string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs[l.n%peerErrorLogSize] = PeerError{
+		Time: nowFunc(),
+		Peer: peerName(peer),
+		Key:  key,
+		Err:  err,
+	}
+	l.n++
+}
+
+func (l *peerErrorLog) recent() []PeerError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []PeerError
+	start := 0
+	if l.n > peerErrorLogSize {
+		start = l.n - peerErrorLogSize
+	}
+	for i := start; i < l.n; i++ {
+		errs = append(errs, l.errs[i%peerErrorLogSize])
+	}
+	return errs
+}
+
+// PeerErrors returns the most recent failures of requests to peers,
+// oldest first, for debugging. A key whose owner fails is asked of
+// the owner's fallbacks in turn (see GroupOptions.PeerFallbacks), and
+// loaded locally once they have all failed, so these errors are
+// otherwise only visible in Stats.PeerErrors. An error returned by
+// a peer's Getter is not a failure of the request: it is returned
+// to the caller as is, and is not recorded here.
+func (g *Group) PeerErrors() []PeerError {
+	return g.peerErrors.recent()
+}
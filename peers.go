@@ -20,6 +20,7 @@ package groupcache
 
 import (
 	"context"
+	"errors"
 
 	pb "github.com/golang/groupcache/groupcachepb"
 )
@@ -30,10 +31,48 @@ type Context = context.Context
 
 // ProtoGetter is the interface that must be implemented by a peer.
 // Tips：ProtoGetter 这个接口必须由 对方 实现？？？
+//
+// Peers may also implement PeerSetter, PeerRemover and
+// PeerMultiGetter for the operations beyond Get.
 type ProtoGetter interface {
 	Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error
 }
 
+// ErrPeerUnsupported is returned by operations that a peer doesn't
+// implement and that can't be carried out otherwise. Peers may also
+// return it, possibly wrapped, when the process they talk to turns
+// out to predate the operation.
+var ErrPeerUnsupported = errors.New("groupcache: operation not supported by peer")
+
+// PeerSetter is implemented by peers that can store a value.
+// Group.Set fails with ErrPeerUnsupported if the key's owner isn't one.
+// 可以写入值的 peer
+type PeerSetter interface {
+	// Set asks the peer to store in's value in its main cache.
+	// 通知 peer 将值存入其 mainCache
+	Set(ctx context.Context, in *pb.SetRequest, out *pb.SetResponse) error
+}
+
+// PeerRemover is implemented by peers that can evict a key.
+// Group.Remove skips the peers that aren't, or that fail with
+// ErrPeerUnsupported.
+// 可以删除 key 的 peer
+type PeerRemover interface {
+	// Remove asks the peer to evict in's key from its caches.
+	// 通知 peer 从其缓存中删除 key
+	Remove(ctx context.Context, in *pb.RemoveRequest, out *pb.RemoveResponse) error
+}
+
+// PeerMultiGetter is implemented by peers that can answer several
+// keys in one request. Group.GetMulti asks the peers that aren't for
+// their keys one by one.
+// 可以批量获取的 peer
+type PeerMultiGetter interface {
+	// GetMulti asks the peer for several keys in one round trip.
+	// 一次请求向 peer 批量获取多个 key
+	GetMulti(ctx context.Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error
+}
+
 // PeerPicker is the interface that must be implemented to locate
 // the peer that owns a specific key.
 // PeerPicker是必须实现的接口，用于定位拥有特定密钥的对等体peer
@@ -44,26 +83,44 @@ type PeerPicker interface {
 	PickPeer(key string) (peer ProtoGetter, ok bool)
 }
 
+// PeerLister is implemented by PeerPickers that can enumerate their
+// peers. Group.Remove uses it to tell every peer to drop a key that
+// it may be holding in its hot cache.
+// PeerLister 可以列出所有 peer，用于将删除操作通知到每个 peer
+type PeerLister interface {
+	// ListPeers returns all peers other than the current one.
+	ListPeers() []ProtoGetter
+}
+
+// FallbackPicker is implemented by PeerPickers that can name the
+// peers that take over a key while its owner is unreachable. Every
+// process must name the same peers for a key, so that the load of the
+// key moves to one process instead of to each requester.
+// FallbackPicker 给出 owner 不可达时接替加载 key 的 peer，
+// 各进程对同一 key 必须给出相同的结果，使加载集中到一个进程
+type FallbackPicker interface {
+	// PickFallbacks returns up to n peers that follow key's owner
+	// in the key's preference list, in order. The list stops before
+	// the current process, which is then the next in line to load
+	// the key.
+	PickFallbacks(key string, n int) []ProtoGetter
+}
+
 // NoPeers is an implementation of PeerPicker that never finds a peer.
 type NoPeers struct{}
 
 func (NoPeers) PickPeer(key string) (peer ProtoGetter, ok bool) { return }
 
-var (
-	portPicker func(groupName string) PeerPicker
-)
-
 // RegisterPeerPicker registers the peer initialization function.
 // It is called once, when the first group is created.
 // Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
 // called exactly once, but not both.
 // RegisterPeerPicker 注册 peer 初始化函数。 在创建第一个组时调用一次。
 // RegisterPeerPicker 或 RegisterPerGroupPeerPicker 应该只调用一次，但不能同时调用
+//
+// RegisterPeerPicker registers fn with DefaultRegistry.
 func RegisterPeerPicker(fn func() PeerPicker) {
-	if portPicker != nil {
-		panic("RegisterPeerPicker called more than once")
-	}
-	portPicker = func(_ string) PeerPicker { return fn() }
+	DefaultRegistry.RegisterPeerPicker(fn)
 }
 
 // RegisterPerGroupPeerPicker registers the peer initialization function,
@@ -71,20 +128,8 @@ func RegisterPeerPicker(fn func() PeerPicker) {
 // It is called once, when the first group is created.
 // Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
 // called exactly once, but not both.
+//
+// RegisterPerGroupPeerPicker registers fn with DefaultRegistry.
 func RegisterPerGroupPeerPicker(fn func(groupName string) PeerPicker) {
-	if portPicker != nil {
-		panic("RegisterPeerPicker called more than once")
-	}
-	portPicker = fn
-}
-
-func getPeers(groupName string) PeerPicker {
-	if portPicker == nil {
-		return NoPeers{}
-	}
-	pk := portPicker(groupName)
-	if pk == nil {
-		pk = NoPeers{}
-	}
-	return pk
+	DefaultRegistry.RegisterPerGroupPeerPicker(fn)
 }
@@ -0,0 +1,161 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+)
+
+const (
+	// maxRateEntries bounds the number of keys whose request rate a
+	// Group tracks, across all shards. The least recently requested
+	// keys are dropped first, so popular keys keep being tracked.
+	maxRateEntries = 4096
+
+	// rateWindow is the window over which request rates are measured.
+	rateWindow = time.Minute
+
+	// defaultHotCacheQPS is the default GroupOptions.HotCacheQPS.
+	defaultHotCacheQPS = 1
+)
+
+// rateTracker measures the recent request rate of keys. Like a cache,
+// it is split into shards by key hash, each behind its own lock, so
+// that requests of different keys don't contend.
+type rateTracker struct {
+	initOnce sync.Once
+	shards   []*rateShard
+
+	// nshards is the number of shards, set when the group is
+	// created. Zero means one.
+	nshards int
+}
+
+// rateShard tracks the keys of one shard of a rateTracker.
+type rateShard struct {
+	mu  sync.Mutex
+	lru *lru.Cache // of *keyRate
+}
+
+// shard returns the shard tracking key.
+func (t *rateTracker) shard(key string) *rateShard {
+	t.initOnce.Do(func() {
+		n := t.nshards
+		if n < 1 {
+			n = 1
+		}
+		per := maxRateEntries / n
+		if per < 1 {
+			per = 1
+		}
+		t.shards = make([]*rateShard, n)
+		for i := range t.shards {
+			t.shards[i] = &rateShard{lru: lru.New(per)}
+		}
+	})
+	if len(t.shards) == 1 {
+		return t.shards[0]
+	}
+	return t.shards[keyHash(key)%uint64(len(t.shards))]
+}
+
+// keyRate counts the requests of a key in the current and the
+// previous rateWindow. The rate is estimated over a sliding window,
+// weighting the previous window by how much of it is still covered.
+type keyRate struct {
+	start     time.Time // of the current window
+	cur, prev float64   // requests in the current and previous windows
+}
+
+// add records a request of key and returns key's request rate, in
+// queries per second, including that request.
+func (t *rateTracker) add(key string, now time.Time) float64 {
+	s := t.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var r *keyRate
+	if ri, ok := s.lru.Get(key); ok {
+		r = ri.(*keyRate)
+	} else {
+		r = &keyRate{start: now}
+		s.lru.Add(key, r)
+	}
+	r.advance(now)
+	r.cur++
+	return r.qps(now)
+}
+
+// rate returns key's request rate, in queries per second, or zero if
+// key isn't tracked.
+func (t *rateTracker) rate(key string, now time.Time) float64 {
+	s := t.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ri, ok := s.lru.Get(key)
+	if !ok {
+		return 0
+	}
+	r := ri.(*keyRate)
+	r.advance(now)
+	return r.qps(now)
+}
+
+// advance moves r's current window forward to the one holding now.
+func (r *keyRate) advance(now time.Time) {
+	switch d := now.Sub(r.start); {
+	case d < rateWindow:
+	case d < 2*rateWindow:
+		r.prev, r.cur = r.cur, 0
+		r.start = r.start.Add(rateWindow)
+	default:
+		r.prev, r.cur = 0, 0
+		r.start = now
+	}
+}
+
+func (r *keyRate) qps(now time.Time) float64 {
+	covered := 1 - float64(now.Sub(r.start))/float64(rateWindow)
+	return (r.prev*covered + r.cur) / rateWindow.Seconds()
+}
+
+// keyQPS returns the request rate of key to report to peers.
+func (g *Group) keyQPS(key string) float64 {
+	return g.rates.rate(key, nowFunc())
+}
+
+// isHot reports whether key, fetched from its owner, should be
+// mirrored into the hot cache. ownerQPS is the key's request rate as
+// seen by the owner; zero if the owner doesn't report it.
+func (g *Group) isHot(key string, ownerQPS float64) bool {
+	threshold := g.opts.HotCacheQPS
+	if threshold == 0 {
+		threshold = defaultHotCacheQPS
+	}
+	if threshold < 0 {
+		return false
+	}
+	localQPS := g.rates.rate(key, nowFunc())
+	if localQPS >= threshold {
+		return true
+	}
+	// A key that's hot across the cluster is only worth a slot
+	// here if this process asked for it more than once lately.
+	return ownerQPS >= threshold && localQPS*rateWindow.Seconds() >= 2
+}
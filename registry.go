@@ -0,0 +1,214 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/golang/groupcache/singleflight"
+)
+
+// A Registry is a set of named groups together with the peer picker,
+// hooks and HTTPPool they share. Each Registry is an independent
+// cache universe: groups in different registries never see each
+// other, so a process may run several of them side by side.
+//
+// The package-level functions such as NewGroup, GetGroup and
+// RegisterPeerPicker operate on DefaultRegistry.
+type Registry struct {
+	mu     sync.RWMutex // guards groups
+	groups map[string]*Group
+
+	// The fields below are set by the Register functions, which
+	// are meant to be called during initialization. They may be
+	// called from the server start hook, which runs with mu held,
+	// so they don't take mu themselves.
+
+	initPeerServerOnce sync.Once
+	initPeerServer     func()
+
+	// newGroupHook, if non-nil, is called right after a new group is created.
+	newGroupHook func(*Group)
+
+	portPicker func(groupName string) PeerPicker
+
+	// httpPoolMade records whether an HTTPPool was created for r.
+	httpPoolMade bool
+}
+
+// DefaultRegistry is the Registry used by the package-level functions.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*Group)}
+}
+
+// GetGroup returns the named group previously created with r.NewGroup,
+// or nil if there's no such group.
+func (r *Registry) GetGroup(name string) *Group {
+	r.mu.RLock()
+	g := r.groups[name]
+	r.mu.RUnlock()
+	return g
+}
+
+// Groups returns the groups of r, sorted by name.
+func (r *Registry) Groups() []*Group {
+	r.mu.RLock()
+	groups := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		groups = append(groups, g)
+	}
+	r.mu.RUnlock()
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	return groups
+}
+
+// NewGroup creates a coordinated group-aware Getter from a Getter and
+// registers it in r. See the package-level NewGroup for details.
+//
+// The group name must be unique within r.
+func (r *Registry) NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+	return r.newGroup(name, cacheBytes, getter, nil, nil)
+}
+
+// NewGroupOpts is like NewGroup, but configures the group with the
+// given options. A nil o is equivalent to an empty GroupOptions.
+func (r *Registry) NewGroupOpts(name string, cacheBytes int64, getter Getter, o *GroupOptions) *Group {
+	return r.newGroup(name, cacheBytes, getter, nil, o)
+}
+
+// If peers is nil, the peerPicker is called via a sync.Once to initialize it.
+func (r *Registry) newGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker, o *GroupOptions) *Group {
+	if getter == nil {
+		panic("nil Getter")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.initPeerServerOnce.Do(r.callInitPeerServer)
+	if _, dup := r.groups[name]; dup {
+		panic("duplicate registration of group " + name)
+	}
+	g := &Group{
+		name:       name,
+		getter:     getter,
+		registry:   r,
+		peers:      peers,
+		cacheBytes: cacheBytes,
+		loadGroup:  &singleflight.Group[string, ByteView]{},
+	}
+	if o != nil {
+		g.opts = *o
+	}
+	g.getter = interceptGetter(name, getter, g.opts.GetterInterceptors)
+	g.mainCache.grace = g.opts.StaleWhileRevalidate
+	g.hotCache.grace = g.opts.StaleWhileRevalidate
+	g.mainCache.newPolicy = g.opts.NewEvictionPolicy
+	g.hotCache.newPolicy = g.opts.NewEvictionPolicy
+	g.mainCache.nshards = g.opts.CacheShards
+	g.hotCache.nshards = g.opts.CacheShards
+	g.rates.nshards = g.opts.CacheShards
+	if g.opts.DiskDir != "" && g.opts.DiskBytes > 0 {
+		g.disk = &diskCache{dir: g.opts.DiskDir, maxBytes: g.opts.DiskBytes, errors: &g.Stats.DiskErrors}
+	}
+	g.split.init(g.opts.HotCacheRatio)
+	if g.opts.AdaptiveHotCache {
+		g.mainCache.maxGhostBytes = cacheBytes
+		g.hotCache.maxGhostBytes = cacheBytes
+	}
+	if fn := r.newGroupHook; fn != nil {
+		fn(g)
+	}
+	r.groups[name] = g
+	return g
+}
+
+// DeregisterGroup removes the named group from r, so that GetGroup no
+// longer returns it and its name may be registered again. The group
+// itself keeps working for callers still holding it; use Group.Close
+// to also release its cached values.
+func (r *Registry) DeregisterGroup(name string) {
+	r.mu.Lock()
+	delete(r.groups, name)
+	r.mu.Unlock()
+}
+
+// deregister removes g from r, unless its name was already reused.
+func (r *Registry) deregister(g *Group) {
+	r.mu.Lock()
+	if r.groups[g.name] == g {
+		delete(r.groups, g.name)
+	}
+	r.mu.Unlock()
+}
+
+// RegisterNewGroupHook registers a hook that is run each time
+// a group is created in r.
+func (r *Registry) RegisterNewGroupHook(fn func(*Group)) {
+	if r.newGroupHook != nil {
+		panic("RegisterNewGroupHook called more than once")
+	}
+	r.newGroupHook = fn
+}
+
+// RegisterServerStart registers a hook that is run when the first
+// group is created in r.
+func (r *Registry) RegisterServerStart(fn func()) {
+	if r.initPeerServer != nil {
+		panic("RegisterServerStart called more than once")
+	}
+	r.initPeerServer = fn
+}
+
+// callInitPeerServer runs the server start hook. r.mu is held.
+func (r *Registry) callInitPeerServer() {
+	if r.initPeerServer != nil {
+		r.initPeerServer()
+	}
+}
+
+// RegisterPeerPicker registers the peer initialization function for
+// the groups of r. It is called once per group, when the group is
+// first used. Either RegisterPeerPicker or RegisterPerGroupPeerPicker
+// should be called exactly once, but not both.
+func (r *Registry) RegisterPeerPicker(fn func() PeerPicker) {
+	r.RegisterPerGroupPeerPicker(func(_ string) PeerPicker { return fn() })
+}
+
+// RegisterPerGroupPeerPicker registers the peer initialization function,
+// which takes the groupName, to be used in choosing a PeerPicker for
+// the groups of r. Either RegisterPeerPicker or
+// RegisterPerGroupPeerPicker should be called exactly once, but not both.
+func (r *Registry) RegisterPerGroupPeerPicker(fn func(groupName string) PeerPicker) {
+	if r.portPicker != nil {
+		panic("RegisterPeerPicker called more than once")
+	}
+	r.portPicker = fn
+}
+
+func (r *Registry) getPeers(groupName string) PeerPicker {
+	if r.portPicker == nil {
+		return NoPeers{}
+	}
+	pk := r.portPicker(groupName)
+	if pk == nil {
+		pk = NoPeers{}
+	}
+	return pk
+}
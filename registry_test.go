@@ -0,0 +1,103 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"testing"
+)
+
+func echoGetter(prefix string) Getter {
+	return GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString(prefix + key)
+	})
+}
+
+func TestRegistryIsolation(t *testing.T) {
+	r1, r2 := NewRegistry(), NewRegistry()
+	g1 := r1.NewGroup("shared-name", cacheSize, echoGetter("r1:"))
+	g2 := r2.NewGroup("shared-name", cacheSize, echoGetter("r2:"))
+
+	if got := r1.GetGroup("shared-name"); got != g1 {
+		t.Errorf("r1.GetGroup = %p; want %p", got, g1)
+	}
+	if got := r2.GetGroup("shared-name"); got != g2 {
+		t.Errorf("r2.GetGroup = %p; want %p", got, g2)
+	}
+	if got := GetGroup("shared-name"); got != nil {
+		t.Errorf("DefaultRegistry has group %p; want nil", got)
+	}
+
+	var s string
+	if err := g2.Get(dummyCtx, "k", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "r2:k" {
+		t.Errorf("Get = %q; want %q", s, "r2:k")
+	}
+}
+
+func TestRegistryHooks(t *testing.T) {
+	r := NewRegistry()
+	var started, created int
+	r.RegisterServerStart(func() { started++ })
+	r.RegisterNewGroupHook(func(*Group) { created++ })
+	r.RegisterPeerPicker(func() PeerPicker { return fakePeers{&fakePeer{}} })
+
+	g := r.NewGroup("a", cacheSize, echoGetter(""))
+	r.NewGroup("b", cacheSize, echoGetter(""))
+	if started != 1 || created != 2 {
+		t.Errorf("server start ran %d times and group hook %d times; want 1 and 2", started, created)
+	}
+
+	var s string
+	if err := g.Get(dummyCtx, "k", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "got:k" {
+		t.Errorf("Get = %q; want the registry's peer to answer %q", s, "got:k")
+	}
+}
+
+func TestGroupClose(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGroup("closing", cacheSize, echoGetter(""))
+	var s string
+	if err := g.Get(dummyCtx, "k", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+
+	g.Close()
+	if got := r.GetGroup("closing"); got != nil {
+		t.Errorf("GetGroup after Close = %p; want nil", got)
+	}
+	if st := g.CacheStats(MainCache); st.Items != 0 || st.Bytes != 0 {
+		t.Errorf("mainCache after Close has %d items, %d bytes; want none", st.Items, st.Bytes)
+	}
+
+	// The name can be reused, and closing the old group again
+	// leaves the new one registered.
+	g2 := r.NewGroup("closing", cacheSize, echoGetter(""))
+	g.Close()
+	if got := r.GetGroup("closing"); got != g2 {
+		t.Errorf("GetGroup = %p; want the new group %p", got, g2)
+	}
+	r.DeregisterGroup("closing")
+	if got := r.GetGroup("closing"); got != nil {
+		t.Errorf("GetGroup after DeregisterGroup = %p; want nil", got)
+	}
+}
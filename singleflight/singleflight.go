@@ -18,61 +18,331 @@ limitations under the License.
 // 提供了一个重复函数调用抑制机制
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// A PanicError is the error returned to the callers waiting for a
+// function that panicked. The caller that started the function panics
+// with it instead.
+// 函数 panic 时返回给等待者的错误；发起调用的一方则以它再次 panic
+type PanicError struct {
+	Value interface{} // the value passed to panic
+	Stack []byte      // the stack of the panicking goroutine
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: function panicked: %v\n\n%s", p.Value, p.Stack)
+}
+
+// errGoexit is the error of a call whose function called
+// runtime.Goexit.
+var errGoexit = errors.New("singleflight: function called runtime.Goexit")
+
+// A Result holds the results of a call, as delivered by DoChan.
+// DoChan 返回的调用结果
+type Result[V any] struct {
+	Val    V
+	Err    error
+	Shared bool // the result of a call started by another caller
+}
 
 // call is an in-flight or completed Do call
 // 在执行的或者已经完成的Do过程
-type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+type call[V any] struct {
+	// done is closed when the call completes.
+	// 调用完成时关闭
+	done chan struct{}
+	val  V
+	err  error
+
+	// ctx is the context of the function of a DoContext call, and
+	// waiters the number of callers still waiting for it. They are
+	// nil and unused for Do calls. waiters is guarded by Group.mu.
+	// DoContext 调用的函数所用的上下文，以及仍在等待结果的调用方数量
+	ctx     *flightContext
+	waiters int
 }
 
 // Group represents a class of work and forms a namespace in which
-// units of work can be executed with duplicate suppression.
+// units of work can be executed with duplicate suppression. Units of
+// work are identified by keys of type K and return values of type V.
 // 表示一类工作，组成一个命名空间的概念，一个group的调用会有“重复抑制”
-type Group struct {
-	mu sync.Mutex       // protects m
+type Group[K comparable, V any] struct {
+	mu sync.Mutex // protects m
 	// 懒惰地初始化；这个map的value是*call，call是上面那个struct
-	m  map[string]*call // lazily initialized
+	m map[K]*call[V] // lazily initialized
 }
 
 // Do executes and returns the results of the given function, making
 // sure that only one execution is in-flight for a given key at a
 // time. If a duplicate comes in, the duplicate caller waits for the
-// original to complete and receives the same results.
+// original to complete and receives the same results. The return
+// value shared reports whether the caller received the results of a
+// call started by another caller.
 // Do接收一个函数，执行并返回结果，
 // 这个过程中确保同一个key在同一时间只有一个执行过程；
 // 重复的调用会等待最原始的调用过程完成，然后接收到相同的结果
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+func (g *Group[K, V]) Do(key K, fn func() (V, error)) (v V, err error, shared bool) {
 	g.mu.Lock()
 	if g.m == nil {
-		g.m = make(map[string]*call)
+		g.m = make(map[K]*call[V])
 	}
 	// 如果这个call存在同名过程，等待初始调用完成，然后返回val和err
-	if c, ok := g.m[key]; ok {
+	if c, ok := g.m[key]; ok && c.live() {
+		// 不会提前离开的等待者，使 DoContext 的函数一直运行
+		c.join(context.Background())
 		g.mu.Unlock()
-		c.wg.Wait()
-		// 当所有goroutine执行完毕，call中就存储了执行结果val和err，然后这里返回
-		return c.val, c.err
+		<-c.done
+		// 当call执行完毕，call中就存储了执行结果val和err，然后这里返回
+		return c.val, c.err, true
 	}
 	// 拿到call结构体类型的指针
-	c := new(call)
-	// 一个goroutine开始，Add(1)，这里最多只会执行到一次，也就是不会并发调用下面的fn()
-	c.wg.Add(1)
+	c := &call[V]{done: make(chan struct{})}
 	// 类似设置一个函数调用的名字“key”对应调用过程c
 	g.m[key] = c
 	g.mu.Unlock()
 
 	// 函数调用过程
-	c.val, c.err = fn()
-	// 这里的Done对应上面if里面的Wait
-	c.wg.Done()
+	g.run(key, c, func(context.Context) (V, error) { return fn() })
+	if p, ok := c.err.(*PanicError); ok {
+		panic(p)
+	}
+	return c.val, c.err, false
+}
+
+// DoChan is like Do but returns a channel that will receive the
+// results when they are ready. A panic of the function is delivered
+// as a PanicError instead of being re-raised.
+// 与 Do 类似，但返回一个接收结果的 channel；函数的 panic 以 PanicError 返回
+func (g *Group[K, V]) DoChan(key K, fn func() (V, error)) <-chan Result[V] {
+	ch := make(chan Result[V], 1)
+	go func() {
+		// 函数调用 runtime.Goexit 时 Do 不会返回
+		res := Result[V]{Err: errGoexit}
+		defer func() {
+			if r := recover(); r != nil {
+				p, ok := r.(*PanicError)
+				if !ok {
+					p = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+				res = Result[V]{Err: p}
+			}
+			ch <- res
+		}()
+		res.Val, res.Err, res.Shared = g.Do(key, fn)
+	}()
+	return ch
+}
 
+// Forget tells the Group to forget about key. Future calls for key
+// run the function rather than waiting for an earlier call to
+// complete; callers already waiting still get its results.
+// 忘记 key：之后的调用重新执行函数，不再等待进行中的调用
+func (g *Group[K, V]) Forget(key K) {
 	g.mu.Lock()
-	// 执行完成，删除这个key
 	delete(g.m, key)
 	g.mu.Unlock()
+}
+
+// DoContext is like Do, but each caller only waits for the result
+// while its own context is not done; if it is done first, the caller
+// returns its error.
+//
+// The function runs in its own goroutine with a context that carries
+// the values of the first caller's context, but not its cancellation.
+// That context is cancelled once no caller waits any more, and has the
+// latest deadline of the callers, if they all have one.
+// DoContext 与 Do 类似，但每个调用方在自己的上下文结束时立即返回；
+// 函数在独立的上下文中运行，直到没有调用方等待时才被取消
+func (g *Group[K, V]) DoContext(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (v V, err error, shared bool) {
+	// 调用方已经放弃，无需执行
+	if err := ctx.Err(); err != nil {
+		return v, err, false
+	}
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[K]*call[V])
+	}
+	c, ok := g.m[key]
+	started := !ok || !c.live()
+	if started {
+		c = &call[V]{done: make(chan struct{}), ctx: newFlightContext(ctx), waiters: 1}
+		g.m[key] = c
+		go g.run(key, c, fn)
+	} else {
+		c.join(ctx)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		if p, ok := c.err.(*PanicError); ok && started {
+			panic(p)
+		}
+		return c.val, c.err, !started
+	case <-ctx.Done():
+	}
+	g.mu.Lock()
+	if c.ctx != nil {
+		c.waiters--
+		if c.waiters == 0 {
+			// 没有调用方再等待，取消函数；后来的调用重新执行
+			c.ctx.stop(ctx.Err())
+			if g.m[key] == c {
+				delete(g.m, key)
+			}
+		}
+	}
+	g.mu.Unlock()
+	return v, ctx.Err(), !started
+}
+
+// run runs the function of c and completes c, even if the function
+// panics or exits its goroutine. A panic is recovered into a
+// PanicError.
+// 执行函数并完成调用；函数 panic 或退出 goroutine 时同样释放等待者
+func (g *Group[K, V]) run(key K, c *call[V], fn func(context.Context) (V, error)) {
+	ctx := context.Background()
+	if c.ctx != nil {
+		ctx = c.ctx
+	}
+	defer func() {
+		// 通知所有等待者
+		close(c.done)
+
+		g.mu.Lock()
+		// 执行完成，删除这个key
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+		g.mu.Unlock()
+		if c.ctx != nil {
+			c.ctx.stop(context.Canceled)
+		}
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			var zero V
+			c.val, c.err = zero, &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	// 若函数调用了 runtime.Goexit，则不会返回
+	c.err = errGoexit
+	c.val, c.err = fn(ctx)
+}
+
+// live reports whether c may still complete successfully, so that new
+// callers may wait for it. g.mu is held.
+func (c *call[V]) live() bool {
+	return c.ctx == nil || c.ctx.Err() == nil
+}
+
+// join counts a new caller with ctx among the waiters of c. g.mu is
+// held.
+func (c *call[V]) join(ctx context.Context) {
+	if c.ctx == nil {
+		return
+	}
+	c.waiters++
+	c.ctx.extend(ctx)
+}
+
+// flightContext is the context of the function of a DoContext call.
+// It carries the values of the first caller's context, and is done
+// when cancelled or past its deadline, which extend can postpone.
+// DoContext 函数的上下文：携带首个调用方的值，截止时间可被后来的调用方延后
+type flightContext struct {
+	context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	deadline time.Time // zero if none
+	timer    *time.Timer
+	err      error // why fc is done
+}
+
+func newFlightContext(parent context.Context) *flightContext {
+	ctx, cancel := context.WithCancel(valueContext{parent})
+	fc := &flightContext{Context: ctx, cancel: cancel}
+	if deadline, ok := parent.Deadline(); ok {
+		// The timer may fire at once.
+		fc.mu.Lock()
+		fc.deadline = deadline
+		fc.timer = time.AfterFunc(time.Until(deadline), fc.expire)
+		fc.mu.Unlock()
+	}
+	return fc
+}
+
+// stop cancels fc with err and releases its timer.
+func (fc *flightContext) stop(err error) {
+	fc.mu.Lock()
+	if fc.timer != nil {
+		fc.timer.Stop()
+	}
+	if fc.err == nil {
+		fc.err = err
+	}
+	fc.mu.Unlock()
+	fc.cancel()
+}
+
+func (fc *flightContext) expire() {
+	fc.stop(context.DeadlineExceeded)
+}
+
+// extend postpones the deadline of fc to cover a caller with ctx.
+func (fc *flightContext) extend(ctx context.Context) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.timer == nil {
+		return // no deadline
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		fc.timer.Stop()
+		fc.timer = nil
+		fc.deadline = time.Time{}
+		return
+	}
+	if deadline.After(fc.deadline) && fc.timer.Stop() {
+		fc.deadline = deadline
+		fc.timer.Reset(time.Until(deadline))
+	}
+}
 
-	return c.val, c.err
+func (fc *flightContext) Deadline() (time.Time, bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.deadline, !fc.deadline.IsZero()
 }
+
+func (fc *flightContext) Err() error {
+	err := fc.Context.Err()
+	if err == nil {
+		return nil
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.err != nil {
+		return fc.err
+	}
+	return err
+}
+
+// valueContext is a context with the values of its parent, but which
+// is never done.
+type valueContext struct {
+	parent context.Context
+}
+
+func (valueContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (valueContext) Done() <-chan struct{}               { return nil }
+func (valueContext) Err() error                          { return nil }
+func (c valueContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
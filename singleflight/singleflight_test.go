@@ -17,8 +17,11 @@ limitations under the License.
 package singleflight
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -26,8 +29,8 @@ import (
 )
 
 func TestDo(t *testing.T) {
-	var g Group
-	v, err := g.Do("key", func() (interface{}, error) {
+	var g Group[string, interface{}]
+	v, err, _ := g.Do("key", func() (interface{}, error) {
 		return "bar", nil
 	})
 	if got, want := fmt.Sprintf("%v (%T)", v, v), "bar (string)"; got != want {
@@ -39,9 +42,9 @@ func TestDo(t *testing.T) {
 }
 
 func TestDoErr(t *testing.T) {
-	var g Group
+	var g Group[string, interface{}]
 	someErr := errors.New("some error")
-	v, err := g.Do("key", func() (interface{}, error) {
+	v, err, _ := g.Do("key", func() (interface{}, error) {
 		return nil, someErr
 	})
 	if err != someErr {
@@ -53,7 +56,7 @@ func TestDoErr(t *testing.T) {
 }
 
 func TestDoDupSuppress(t *testing.T) {
-	var g Group
+	var g Group[string, interface{}]
 	c := make(chan string)
 	var calls int32
 	fn := func() (interface{}, error) {
@@ -66,7 +69,7 @@ func TestDoDupSuppress(t *testing.T) {
 	for i := 0; i < n; i++ {
 		wg.Add(1)
 		go func() {
-			v, err := g.Do("key", fn)
+			v, err, _ := g.Do("key", fn)
 			if err != nil {
 				t.Errorf("Do error: %v", err)
 			}
@@ -83,3 +86,305 @@ func TestDoDupSuppress(t *testing.T) {
 		t.Errorf("number of calls = %d; want 1", got)
 	}
 }
+
+func TestDoContextWaiterLeaves(t *testing.T) {
+	var g Group[string, interface{}]
+	type key struct{}
+	release := make(chan bool)
+	fnCtx := make(chan context.Context, 1)
+	fn := func(ctx context.Context) (interface{}, error) {
+		fnCtx <- ctx
+		<-release
+		return "bar", nil
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.WithValue(context.Background(), key{}, "first"))
+	errc := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoContext(ctx1, "key", fn)
+		errc <- err
+	}()
+	ctx := <-fnCtx
+	resc := make(chan interface{}, 1)
+	go func() {
+		v, err, _ := g.DoContext(context.Background(), "key", fn)
+		if err != nil {
+			t.Errorf("second DoContext error: %v", err)
+		}
+		resc <- v
+	}()
+	time.Sleep(50 * time.Millisecond) // let the second caller join
+
+	// The first caller leaves, but the function goes on for the second.
+	cancel1()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("first DoContext error = %v; want %v", err, context.Canceled)
+	}
+	if err := ctx.Err(); err != nil {
+		t.Errorf("function's context done while a caller waits: %v", err)
+	}
+	if v := ctx.Value(key{}); v != "first" {
+		t.Errorf("function's context value = %v; want the first caller's", v)
+	}
+	release <- true
+	if v := <-resc; v != "bar" {
+		t.Errorf("second DoContext = %v; want bar", v)
+	}
+}
+
+func TestDoContextAllLeave(t *testing.T) {
+	var g Group[string, interface{}]
+	var calls int32
+	fnCtx := make(chan context.Context, 2)
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		fnCtx <- ctx
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err, _ := g.DoContext(ctx, "key", fn); err != context.Canceled {
+				t.Errorf("DoContext error = %v; want %v", err, context.Canceled)
+			}
+		}()
+	}
+	loadCtx := <-fnCtx
+	time.Sleep(50 * time.Millisecond) // let the second caller join
+	cancel()
+	wg.Wait()
+	select {
+	case <-loadCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("function's context not done after every caller left")
+	}
+
+	// A new call doesn't wait for the abandoned one.
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	go func() {
+		<-fnCtx
+		cancel2()
+	}()
+	g.DoContext(ctx2, "key", fn)
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("number of calls = %d; want 2", got)
+	}
+}
+
+func TestDoContextDeadline(t *testing.T) {
+	var g Group[string, interface{}]
+	now := time.Now()
+	ctx1, cancel1 := context.WithDeadline(context.Background(), now.Add(time.Hour))
+	defer cancel1()
+	ctx2, cancel2 := context.WithDeadline(context.Background(), now.Add(2*time.Hour))
+	defer cancel2()
+
+	release := make(chan bool)
+	deadlines := make(chan time.Time)
+	fn := func(ctx context.Context) (interface{}, error) {
+		for range release {
+			d, _ := ctx.Deadline()
+			deadlines <- d
+		}
+		return nil, nil
+	}
+	done := make(chan bool)
+	for _, ctx := range []context.Context{ctx1, ctx2} {
+		go func(ctx context.Context) {
+			g.DoContext(ctx, "key", fn)
+			done <- true
+		}(ctx)
+		time.Sleep(50 * time.Millisecond) // let the caller start or join
+		release <- true
+		if d := <-deadlines; d.IsZero() {
+			t.Error("function's context has no deadline")
+		} else if want, _ := ctx.Deadline(); !d.Equal(want) {
+			t.Errorf("function's deadline = %v; want the latest caller's %v", d, want)
+		}
+	}
+	close(release)
+	<-done
+	<-done
+
+	// The function's context expires with the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fnErr := make(chan error, 1)
+	g.DoContext(ctx, "expiring", func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		fnErr <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	if err := <-fnErr; err != context.DeadlineExceeded {
+		t.Errorf("function's context error = %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	var g Group[string, interface{}]
+	started, release := make(chan bool), make(chan bool)
+	fn := func() (interface{}, error) {
+		close(started)
+		<-release
+		panic("boom")
+	}
+
+	waiterErr := make(chan error, 1)
+	leaderPanic := make(chan interface{}, 1)
+	go func() {
+		defer func() { leaderPanic <- recover() }()
+		g.Do("key", fn)
+	}()
+	<-started
+	go func() {
+		_, err, _ := g.Do("key", fn)
+		waiterErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond) // let the waiter block
+	close(release)
+
+	p, ok := (<-leaderPanic).(*PanicError)
+	if !ok || p.Value != "boom" || !strings.Contains(string(p.Stack), "TestDoPanic") {
+		t.Errorf("leader panicked with %#v; want a PanicError of boom with its stack", p)
+	}
+	if err, ok := (<-waiterErr).(*PanicError); !ok || err != p {
+		t.Errorf("waiter error = %v; want the leader's PanicError", err)
+	}
+
+	// The key is free again.
+	v, err, _ := g.Do("key", func() (interface{}, error) { return "bar", nil })
+	if v != "bar" || err != nil {
+		t.Errorf("Do after panic = %v, %v; want bar", v, err)
+	}
+}
+
+func TestDoContextPanic(t *testing.T) {
+	var g Group[string, interface{}]
+	defer func() {
+		if p, ok := recover().(*PanicError); !ok || p.Value != "boom" {
+			t.Errorf("recovered %v; want a PanicError of boom", p)
+		}
+		v, err, _ := g.DoContext(context.Background(), "key", func(context.Context) (interface{}, error) { return "bar", nil })
+		if v != "bar" || err != nil {
+			t.Errorf("DoContext after panic = %v, %v; want bar", v, err)
+		}
+	}()
+	g.DoContext(context.Background(), "key", func(context.Context) (interface{}, error) {
+		panic("boom")
+	})
+	t.Error("DoContext didn't panic")
+}
+
+func TestDoGoexit(t *testing.T) {
+	var g Group[string, interface{}]
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		g.Do("key", func() (interface{}, error) {
+			runtime.Goexit()
+			return nil, nil
+		})
+	}()
+	<-done
+	_, err, _ := g.DoContext(context.Background(), "other", func(context.Context) (interface{}, error) {
+		runtime.Goexit()
+		return nil, nil
+	})
+	if err != errGoexit {
+		t.Errorf("DoContext error = %v; want %v", err, errGoexit)
+	}
+	v, err, _ := g.Do("key", func() (interface{}, error) { return "bar", nil })
+	if v != "bar" || err != nil {
+		t.Errorf("Do after Goexit = %v, %v; want bar", v, err)
+	}
+}
+
+func TestDoShared(t *testing.T) {
+	var g Group[int, string]
+	started, release := make(chan bool), make(chan bool)
+	fn := func() (string, error) {
+		close(started)
+		<-release
+		return "bar", nil
+	}
+	leader := make(chan bool, 1)
+	go func() {
+		_, _, shared := g.Do(1, fn)
+		leader <- shared
+	}()
+	<-started
+	waiter := make(chan bool, 1)
+	go func() {
+		v, _, shared := g.Do(1, fn)
+		if v != "bar" {
+			t.Errorf("waiter got %q; want bar", v)
+		}
+		waiter <- shared
+	}()
+	time.Sleep(50 * time.Millisecond) // let the waiter block
+	close(release)
+	if <-leader {
+		t.Error("leader's result is shared")
+	}
+	if !<-waiter {
+		t.Error("waiter's result isn't shared")
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group[string, int]
+	release := make(chan bool)
+	var calls int32
+	fn := func() (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 42, nil
+	}
+	ch1 := g.DoChan("key", fn)
+	time.Sleep(50 * time.Millisecond) // let the first call start
+	ch2 := g.DoChan("key", fn)
+	time.Sleep(50 * time.Millisecond) // let the second call join
+	close(release)
+	r1, r2 := <-ch1, <-ch2
+	if r1.Val != 42 || r1.Err != nil || r1.Shared {
+		t.Errorf("first result = %+v; want 42, not shared", r1)
+	}
+	if r2.Val != 42 || r2.Err != nil || !r2.Shared {
+		t.Errorf("second result = %+v; want 42, shared", r2)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("number of calls = %d; want 1", got)
+	}
+
+	r := <-g.DoChan("panic", func() (int, error) { panic("boom") })
+	if p, ok := r.Err.(*PanicError); !ok || p.Value != "boom" {
+		t.Errorf("panicking DoChan error = %v; want a PanicError of boom", r.Err)
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group[string, int]
+	started, release := make(chan bool), make(chan bool)
+	first := g.DoChan("key", func() (int, error) {
+		close(started)
+		<-release
+		return 1, nil
+	})
+	<-started
+
+	// After Forget, a new call runs instead of waiting for the first.
+	g.Forget("key")
+	v, _, shared := g.Do("key", func() (int, error) { return 2, nil })
+	if v != 2 || shared {
+		t.Errorf("Do after Forget = %d, shared %v; want 2, not shared", v, shared)
+	}
+	close(release)
+	if r := <-first; r.Val != 1 {
+		t.Errorf("forgotten call = %d; want 1", r.Val)
+	}
+}
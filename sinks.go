@@ -18,6 +18,7 @@ package groupcache
 
 import (
 	"errors"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 )
@@ -43,6 +44,14 @@ type Sink interface {
 	// 调用者保留 m 的所有权
 	SetProto(m proto.Message) error
 
+	// SetExpire sets the time at which the value expires. Once
+	// expired, the value is no longer served from any cache and
+	// the next Get loads it again. The zero Time, which is the
+	// default, means the value never expires. SetExpire may be
+	// called before or after the other Set methods.
+	// 设置值的过期时间，过期后不再从任何缓存返回；零值表示永不过期
+	SetExpire(t time.Time)
+
 	// view returns a frozen view of the bytes for caching.
 	// 返回缓存字节的冻结视图， 注意byteview 类型在返回值里
 	view() (ByteView, error)
@@ -68,6 +77,7 @@ func setSinkView(s Sink, v ByteView) error {
 	if vs, ok := s.(viewSetter); ok {
 		return vs.setView(v)
 	}
+	s.SetExpire(v.e)
 	if v.b != nil {
 		return s.SetBytes(v.b)
 	}
@@ -94,6 +104,10 @@ func (s *stringSink) view() (ByteView, error) {
 	return s.v, nil
 }
 
+func (s *stringSink) SetExpire(t time.Time) {
+	s.v.e = t
+}
+
 // 设置stringsink 子符串属性
 func (s *stringSink) SetString(v string) error {
 	s.v.b = nil
@@ -130,6 +144,7 @@ func ByteViewSink(dst *ByteView) Sink {
 // 属性dst为一个ByteView指针
 type byteViewSink struct {
 	dst *ByteView
+	e   time.Time // expiration applied to every value set
 
 	// if this code ever ends up tracking that at least one set*
 	// method was called, don't make it an error to call set
@@ -153,25 +168,30 @@ func (s *byteViewSink) view() (ByteView, error) {
 	return *s.dst, nil
 }
 
+func (s *byteViewSink) SetExpire(t time.Time) {
+	s.e = t
+	s.dst.e = t
+}
+
 // 设置 byteViewSink 中 ByteView 的 b
 func (s *byteViewSink) SetProto(m proto.Message) error {
 	b, err := proto.Marshal(m)
 	if err != nil {
 		return err
 	}
-	*s.dst = ByteView{b: b}
+	*s.dst = ByteView{b: b, e: s.e}
 	return nil
 }
 
 // 复制b，初始化 byteViewSink 的 dst
 func (s *byteViewSink) SetBytes(b []byte) error {
-	*s.dst = ByteView{b: cloneBytes(b)}
+	*s.dst = ByteView{b: cloneBytes(b), e: s.e}
 	return nil
 }
 
 // 通过使用string类型的v初始化一个 ByteView 后初始化 byteViewSink 的 dst
 func (s *byteViewSink) SetString(v string) error {
-	*s.dst = ByteView{s: v}
+	*s.dst = ByteView{s: v, e: s.e}
 	return nil
 }
 
@@ -195,6 +215,10 @@ func (s *protoSink) view() (ByteView, error) {
 	return s.v, nil
 }
 
+func (s *protoSink) SetExpire(t time.Time) {
+	s.v.e = t
+}
+
 // 将s.dst反序列化后丢给b，并且复制一份丢给 protoSink 中 ByteView 的 b
 func (s *protoSink) SetBytes(b []byte) error {
 	err := proto.Unmarshal(b, s.dst)
@@ -255,6 +279,10 @@ func (s *allocBytesSink) view() (ByteView, error) {
 	return s.v, nil
 }
 
+func (s *allocBytesSink) SetExpire(t time.Time) {
+	s.v.e = t
+}
+
 // 设置allocBytesSink的v，同时复制v中的b或者s丢给dst
 func (s *allocBytesSink) setView(v ByteView) error {
 	if v.b != nil {
@@ -320,6 +348,10 @@ func (s *truncBytesSink) view() (ByteView, error) {
 	return s.v, nil
 }
 
+func (s *truncBytesSink) SetExpire(t time.Time) {
+	s.v.e = t
+}
+
 // 从下面的setBytesOwned开始看
 func (s *truncBytesSink) SetProto(m proto.Message) error {
 	b, err := proto.Marshal(m)
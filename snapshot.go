@@ -0,0 +1,209 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// snapshotMagic starts every snapshot.
+const snapshotMagic = "GCSNAP"
+
+// snapshotVersion is the version of the snapshot format written by
+// Snapshot. Restore reads it and all earlier versions.
+//
+// After the magic and the version, as a uvarint, a snapshot is a
+// sequence of records, each prefixed with its length as a uvarint
+// and ended by a zero length. A record holds, in order: the CacheType
+// of the entry (uvarint), the length of the key (uvarint), the key,
+// the length of the value (uvarint), the value, and the expiration
+// time in Unix nanoseconds, zero if none (varint). Readers ignore
+// any bytes following the fields they know of, so later versions
+// may append fields to records.
+const snapshotVersion = 1
+
+// maxSnapshotRecord bounds the size of a record Restore accepts.
+const maxSnapshotRecord = 1 << 30
+
+// Snapshot writes the entries of the main cache of g to w, and those
+// of the other given caches, such as HotCache, to be loaded later by
+// Restore, typically in a restarted process. Entries are written
+// from the least to the most recently used, so that Restore keeps
+// their recency order. With several CacheShards, the order is kept
+// within each shard.
+func (g *Group) Snapshot(w io.Writer, which ...CacheType) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	buf := appendUvarint(nil, snapshotVersion)
+	bw.Write(buf)
+
+	caches := []CacheType{MainCache}
+	for _, ct := range which {
+		if ct != MainCache {
+			caches = append(caches, ct)
+		}
+	}
+	for _, ct := range caches {
+		var c *cache
+		switch ct {
+		case MainCache:
+			c = &g.mainCache
+		case HotCache:
+			c = &g.hotCache
+		default:
+			return fmt.Errorf("groupcache: can't snapshot cache type %d", ct)
+		}
+		keys, values := c.entries()
+		for i, key := range keys {
+			buf = encodeSnapshotRecord(buf[:0], ct, key, values[i])
+			if _, err := bw.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+	bw.WriteByte(0)
+	return bw.Flush()
+}
+
+func encodeSnapshotRecord(buf []byte, ct CacheType, key string, value ByteView) []byte {
+	var expire int64
+	if e := value.Expire(); !e.IsZero() {
+		expire = e.UnixNano()
+	}
+	var rec []byte
+	rec = appendUvarint(rec, uint64(ct))
+	rec = appendUvarint(rec, uint64(len(key)))
+	rec = append(rec, key...)
+	rec = appendUvarint(rec, uint64(value.Len()))
+	if value.b != nil {
+		rec = append(rec, value.b...)
+	} else {
+		rec = append(rec, value.s...)
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	rec = append(rec, tmp[:binary.PutVarint(tmp[:], expire)]...)
+	buf = appendUvarint(buf, uint64(len(rec)))
+	return append(buf, rec...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+var errBadSnapshot = errors.New("groupcache: malformed snapshot")
+
+// Restore loads into g the entries of a snapshot written by
+// Snapshot, in the order they were written, so that the most
+// recently used entries are the last to be evicted. Entries that
+// have expired are skipped, and so are main cache entries of keys
+// that this process doesn't own under the current PeerPicker.
+// Hot cache entries of keys it now owns go to the main cache.
+//
+// Restore does not remove the entries already cached.
+func (g *Group) Restore(r io.Reader) error {
+	g.peersOnce.Do(g.initPeers)
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != snapshotMagic {
+		return errors.New("groupcache: not a snapshot")
+	}
+	version, err := binary.ReadUvarint(br)
+	if err != nil {
+		return errBadSnapshot
+	}
+	if version > snapshotVersion {
+		return fmt.Errorf("groupcache: unsupported snapshot version %d", version)
+	}
+	now := nowFunc()
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return errBadSnapshot
+		}
+		if n == 0 {
+			return nil
+		}
+		if n > maxSnapshotRecord {
+			return errBadSnapshot
+		}
+		rec := make([]byte, n)
+		if _, err := io.ReadFull(br, rec); err != nil {
+			return errBadSnapshot
+		}
+		ct, key, value, err := decodeSnapshotRecord(rec)
+		if err != nil {
+			return err
+		}
+		if value.expired(now.Add(-g.opts.StaleWhileRevalidate)) {
+			continue
+		}
+		_, remote := g.peers.PickPeer(key)
+		switch {
+		case !remote:
+			g.populateCache(key, value, &g.mainCache)
+		case ct == HotCache:
+			g.populateCache(key, value, &g.hotCache)
+		}
+	}
+}
+
+func decodeSnapshotRecord(rec []byte) (ct CacheType, key string, value ByteView, err error) {
+	next := func() (uint64, bool) {
+		v, n := binary.Uvarint(rec)
+		if n <= 0 {
+			return 0, false
+		}
+		rec = rec[n:]
+		return v, true
+	}
+	bytes := func() ([]byte, bool) {
+		n, ok := next()
+		if !ok || n > uint64(len(rec)) {
+			return nil, false
+		}
+		b := rec[:n]
+		rec = rec[n:]
+		return b, true
+	}
+	t, ok := next()
+	if !ok {
+		return 0, "", ByteView{}, errBadSnapshot
+	}
+	k, ok := bytes()
+	if !ok {
+		return 0, "", ByteView{}, errBadSnapshot
+	}
+	v, ok := bytes()
+	if !ok {
+		return 0, "", ByteView{}, errBadSnapshot
+	}
+	expire, n := binary.Varint(rec)
+	if n <= 0 {
+		return 0, "", ByteView{}, errBadSnapshot
+	}
+	value = ByteView{b: v}
+	if expire != 0 {
+		value.e = time.Unix(0, expire)
+	}
+	return CacheType(t), string(k), value, nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	clock, restore := useFakeClock(time.Unix(1000, 0))
+	defer restore()
+
+	src := DefaultRegistry.newGroup("TestSnapshotRestore-src", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		if key == "expiring" {
+			dest.SetExpire(clock.Now().Add(time.Minute))
+		}
+		return dest.SetString("value-" + key)
+	}), NoPeers{}, nil)
+	defer src.Close()
+	get := func(g *Group, key string) (string, error) {
+		var s string
+		err := g.Get(dummyCtx, key, StringSink(&s))
+		return s, err
+	}
+	for i := 0; i < 10; i++ {
+		get(src, fmt.Sprintf("k%d", i))
+	}
+	get(src, "expiring")
+	get(src, "k0") // now the most recently used
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+
+	// Each entry takes 10 bytes, so the restored group keeps the 5
+	// most recently used.
+	dst := DefaultRegistry.newGroup("TestSnapshotRestore-dst", 50, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return errors.New("not restored")
+	}), NoPeers{}, nil)
+	defer dst.Close()
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"k6", "k7", "k8", "k9", "k0"} {
+		if v, err := get(dst, key); err != nil || v != "value-"+key {
+			t.Errorf("restored Get(%q) = %q, %v; want %q", key, v, err, "value-"+key)
+		}
+	}
+	for _, key := range []string{"k1", "k5", "expiring"} {
+		if _, err := get(dst, key); err == nil {
+			t.Errorf("Get(%q) succeeded; want it not restored", key)
+		}
+	}
+}
+
+func TestSnapshotOwnership(t *testing.T) {
+	peer := &fakePeer{}
+	peers := fakePeers{peer, nil}
+	src := DefaultRegistry.newGroup("TestSnapshotOwnership-src", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("value-" + key)
+	}), NoPeers{}, nil)
+	defer src.Close()
+	remoteKeys := keysOwnedBy(peers, 0, 3)
+	localKeys := keysOwnedBy(peers, 1, 3)
+	for _, key := range append(remoteKeys, localKeys...) {
+		var s string
+		src.Get(dummyCtx, key, StringSink(&s))
+	}
+	// A mirrored entry in the hot cache.
+	src.hotCache.add("hot-key", ByteView{s: "hot-value"})
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf, HotCache); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := DefaultRegistry.newGroup("TestSnapshotOwnership-dst", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return errors.New("not restored")
+	}), peers, nil)
+	defer dst.Close()
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range localKeys {
+		if !dst.mainCache.has(key) {
+			t.Errorf("owned key %q not restored", key)
+		}
+	}
+	for _, key := range remoteKeys {
+		if dst.mainCache.has(key) || dst.hotCache.has(key) {
+			t.Errorf("key %q owned by a peer was restored", key)
+		}
+	}
+	if _, remote := peers.PickPeer("hot-key"); remote && !dst.hotCache.has("hot-key") {
+		t.Error("hot cache entry not restored")
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	g := DefaultRegistry.newGroup("TestRestoreErrors-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}), NoPeers{}, nil)
+	defer g.Close()
+	var good bytes.Buffer
+	g.Get(dummyCtx, "k", StringSink(new(string)))
+	if err := g.Snapshot(&good); err != nil {
+		t.Fatal(err)
+	}
+	b := good.Bytes()
+	for name, in := range map[string]string{
+		"empty":     "",
+		"magic":     "NOTSNAPSHOT",
+		"version":   snapshotMagic + "\x7f",
+		"truncated": string(b[:len(b)-3]),
+		"no end":    string(b[:len(b)-1]),
+	} {
+		if err := g.Restore(strings.NewReader(in)); err == nil {
+			t.Errorf("%s: Restore succeeded; want error", name)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"math"
+	"sync"
+)
+
+// defaultHotCacheRatio is the default GroupOptions.HotCacheRatio. It
+// lets the hot cache grow to an eighth of the main cache.
+const defaultHotCacheRatio = 1.0 / 9
+
+// minAdaptiveHotRatio and maxAdaptiveHotRatio bound the share of the
+// hot cache under GroupOptions.AdaptiveHotCache, so that a burst of
+// misses can't starve either cache. A configured HotCacheRatio outside
+// of them widens them.
+const (
+	minAdaptiveHotRatio = 1.0 / 16
+	maxAdaptiveHotRatio = 1.0 / 2
+)
+
+// cacheSplit is the share of a Group's cacheBytes targeted for its
+// hot cache; the main cache gets the rest. When the group is over its
+// budget, the cache holding more than its share is evicted from.
+//
+// With GroupOptions.AdaptiveHotCache, both caches remember the keys
+// they recently evicted. A miss on a key the main cache evicted means
+// the main cache would have hit with a bigger share, and moves the
+// boundary towards it by the size of that key's entry; likewise for
+// the hot cache, within minAdaptiveHotRatio and maxAdaptiveHotRatio.
+type cacheSplit struct {
+	mu       sync.Mutex
+	hotRatio float64
+	min, max float64 // bounds of hotRatio
+}
+
+func (s *cacheSplit) init(ratio float64) {
+	if ratio <= 0 {
+		ratio = defaultHotCacheRatio
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	s.hotRatio = ratio
+	s.min = math.Min(ratio, minAdaptiveHotRatio)
+	s.max = math.Max(ratio, maxAdaptiveHotRatio)
+}
+
+// hotTarget returns the number of bytes targeted for the hot cache.
+func (s *cacheSplit) hotTarget(cacheBytes int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(s.hotRatio * float64(cacheBytes))
+}
+
+// moveHot moves delta of cacheBytes from the main to the hot cache.
+func (s *cacheSplit) moveHot(delta, cacheBytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hotRatio += float64(delta) / float64(cacheBytes)
+	if s.hotRatio < s.min {
+		s.hotRatio = s.min
+	}
+	if s.hotRatio > s.max {
+		s.hotRatio = s.max
+	}
+}
+
+// noteMiss adapts the split after key was missed in both caches.
+func (g *Group) noteMiss(key string) {
+	if !g.opts.AdaptiveHotCache || g.cacheBytes <= 0 {
+		return
+	}
+	if size, ok := g.mainCache.ghostHit(key); ok {
+		g.split.moveHot(-size, g.cacheBytes)
+	} else if size, ok := g.hotCache.ghostHit(key); ok {
+		g.split.moveHot(size, g.cacheBytes)
+	}
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+// NewTinyLFU returns an EvictionPolicy implementing W-TinyLFU. New
+// entries go into a small LRU window, holding 1% of the entries.
+// Once the cache is full, an entry leaving the window is only
+// admitted into the main area if its
+// estimated access frequency beats that of the entry the main area
+// would evict; otherwise the newcomer is evicted. One-off keys, such
+// as those of a scan, thus don't displace the working set.
+//
+// The main area is a segmented LRU: entries accessed again while on
+// probation are promoted to a protected segment holding up to 80% of
+// the main area. Frequencies are estimated with a count-min sketch
+// whose counters are periodically halved, so that old popularity
+// fades.
+func NewTinyLFU() EvictionPolicy {
+	return &tinyLFUPolicy{
+		window:    newLRUList(),
+		probation: newLRUList(),
+		protected: newLRUList(),
+	}
+}
+
+type tinyLFUPolicy struct {
+	window    lruList
+	probation lruList
+	protected lruList
+	sketch    countMinSketch
+
+	// full is set once Evict has been called. Until then the
+	// cache has room, and entries leaving the window are admitted
+	// into the main area without a contest.
+	full bool
+}
+
+func (p *tinyLFUPolicy) Add(key string, value ByteView) (old ByteView, replaced bool) {
+	p.sketch.ensureWidth(sketchWidthPerEntry * (p.Len() + 1))
+	p.sketch.increment(key)
+	if e, ok := p.hit(key); ok {
+		old, e.value = e.value, value
+		return old, true
+	}
+	p.window.pushFront(&policyEntry{key: key, value: value})
+	for !p.full && p.window.len() > p.windowMax() {
+		e, _ := p.window.oldest()
+		p.window.remove(e)
+		p.probation.pushFront(e)
+	}
+	return
+}
+
+// windowMax is the number of entries the window holds: 1% of all.
+func (p *tinyLFUPolicy) windowMax() int {
+	return maxInt(1, p.Len()/100)
+}
+
+func (p *tinyLFUPolicy) Get(key string) (value ByteView, ok bool) {
+	p.sketch.increment(key)
+	e, ok := p.hit(key)
+	if !ok {
+		return
+	}
+	return e.value, true
+}
+
+// hit records an access to key's entry, wherever it is.
+func (p *tinyLFUPolicy) hit(key string) (*policyEntry, bool) {
+	if e, ok := p.window.get(key); ok {
+		p.window.moveToFront(e)
+		return e, true
+	}
+	if e, ok := p.protected.get(key); ok {
+		p.protected.moveToFront(e)
+		return e, true
+	}
+	e, ok := p.probation.get(key)
+	if !ok {
+		return nil, false
+	}
+	p.probation.remove(e)
+	p.protected.pushFront(e)
+	if max := maxInt(1, (p.probation.len()+p.protected.len())*8/10); p.protected.len() > max {
+		demoted, _ := p.protected.oldest()
+		p.protected.remove(demoted)
+		p.probation.pushFront(demoted)
+	}
+	return e, true
+}
+
+func (p *tinyLFUPolicy) Remove(key string) (value ByteView, ok bool) {
+	for _, l := range []*lruList{&p.window, &p.probation, &p.protected} {
+		if e, ok := l.get(key); ok {
+			l.remove(e)
+			return e.value, true
+		}
+	}
+	return
+}
+
+func (p *tinyLFUPolicy) Evict() (key string, value ByteView, ok bool) {
+	p.full = true
+	for {
+		cand, haveCand := p.window.oldest()
+		if haveCand && p.window.len() > p.windowMax() {
+			p.window.remove(cand)
+			victim, victimList := p.mainVictim()
+			if victim == nil {
+				// The main area is empty: admit for free.
+				p.probation.pushFront(cand)
+				continue
+			}
+			if p.sketch.estimate(cand.key) <= p.sketch.estimate(victim.key) {
+				return cand.key, cand.value, true
+			}
+			victimList.remove(victim)
+			p.probation.pushFront(cand)
+			return victim.key, victim.value, true
+		}
+		if victim, victimList := p.mainVictim(); victim != nil {
+			victimList.remove(victim)
+			return victim.key, victim.value, true
+		}
+		if haveCand {
+			p.window.remove(cand)
+			return cand.key, cand.value, true
+		}
+		return
+	}
+}
+
+// mainVictim returns the entry the main area would evict next, and
+// the list holding it.
+func (p *tinyLFUPolicy) mainVictim() (*policyEntry, *lruList) {
+	if e, ok := p.probation.oldest(); ok {
+		return e, &p.probation
+	}
+	if e, ok := p.protected.oldest(); ok {
+		return e, &p.protected
+	}
+	return nil, nil
+}
+
+func (p *tinyLFUPolicy) Len() int {
+	return p.window.len() + p.probation.len() + p.protected.len()
+}
+
+func (p *tinyLFUPolicy) Range(fn func(key string, value ByteView) bool) {
+	if p.probation.rangeOldest(fn) && p.protected.rangeOldest(fn) {
+		p.window.rangeOldest(fn)
+	}
+}
+
+const (
+	sketchDepth         = 4
+	sketchMinWidth      = 64
+	sketchWidthPerEntry = 4
+	sketchMaxCount      = 15
+)
+
+// countMinSketch estimates how often keys were seen, in a fixed
+// amount of memory. Once it has counted 10 times as many accesses
+// as it has columns, all counts are halved.
+type countMinSketch struct {
+	rows      [sketchDepth][]uint8
+	mask      uint32
+	additions int
+}
+
+// ensureWidth grows the sketch to at least n columns. A key's column
+// in the grown sketch is either its old one or that plus the old
+// width, so copying each row into both halves keeps the counts.
+func (s *countMinSketch) ensureWidth(n int) {
+	width := len(s.rows[0])
+	if width >= n {
+		return
+	}
+	if width == 0 {
+		width = sketchMinWidth
+	}
+	for width < n {
+		width *= 2
+	}
+	for i, row := range s.rows {
+		grown := make([]uint8, width)
+		for j := 0; len(row) > 0 && j < width; j += len(row) {
+			copy(grown[j:], row)
+		}
+		s.rows[i] = grown
+	}
+	s.mask = uint32(width - 1)
+}
+
+func (s *countMinSketch) increment(key string) {
+	if s.rows[0] == nil {
+		s.ensureWidth(sketchMinWidth)
+	}
+	h1, h2 := sketchHash(key)
+	for i := range s.rows {
+		c := &s.rows[i][(h1+uint32(i)*h2)&s.mask]
+		if *c < sketchMaxCount {
+			*c++
+		}
+	}
+	s.additions++
+	if s.additions >= 10*len(s.rows[0]) {
+		s.halve()
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	if s.rows[0] == nil {
+		return 0
+	}
+	h1, h2 := sketchHash(key)
+	min := uint8(sketchMaxCount)
+	for i := range s.rows {
+		if c := s.rows[i][(h1+uint32(i)*h2)&s.mask]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+func (s *countMinSketch) halve() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] /= 2
+		}
+	}
+	s.additions /= 2
+}
+
+// sketchHash returns two hashes of key.
+func sketchHash(key string) (h1, h2 uint32) {
+	h := keyHash(key)
+	return uint32(h), uint32(h>>32) | 1
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// A TraceContext identifies a span of a distributed trace, as carried
+// by the W3C traceparent and tracestate headers. See
+// https://www.w3.org/TR/trace-context/.
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte   // trace flags; 1 is sampled
+	State   string // tracestate header, vendor-specific and opaque
+}
+
+// IsValid reports whether tc has non-zero trace and span IDs.
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// Traceparent formats tc as a version 00 traceparent header.
+func (tc TraceContext) Traceparent() string {
+	var b [55]byte
+	copy(b[:], "00-")
+	hex.Encode(b[3:35], tc.TraceID[:])
+	b[35] = '-'
+	hex.Encode(b[36:52], tc.SpanID[:])
+	b[52] = '-'
+	hex.Encode(b[53:55], []byte{tc.Flags})
+	return string(b[:])
+}
+
+var errBadTraceparent = errors.New("groupcache: malformed traceparent")
+
+// ParseTraceparent parses a traceparent header, with an empty State.
+// Versions after 00 are parsed as 00, as the specification requires.
+func ParseTraceparent(s string) (TraceContext, error) {
+	var tc TraceContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return tc, errBadTraceparent
+	}
+	version := s[:2]
+	if !isLowerHex(version) || version == "ff" || (version == "00" && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return tc, errBadTraceparent
+	}
+	var flags [1]byte
+	for _, f := range []struct {
+		dst []byte
+		src string
+	}{
+		{tc.TraceID[:], s[3:35]},
+		{tc.SpanID[:], s[36:52]},
+		{flags[:], s[53:55]},
+	} {
+		if !isLowerHex(f.src) {
+			return tc, errBadTraceparent
+		}
+		hex.Decode(f.dst, []byte(f.src))
+	}
+	tc.Flags = flags[0]
+	if !tc.IsValid() {
+		return tc, errBadTraceparent
+	}
+	return tc, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+type traceContextKey struct{}
+
+// ContextWithTrace returns a copy of ctx carrying tc.
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceFromContext returns the TraceContext carried by ctx, if any.
+// Requests to peers carry it in their headers, and HTTPPool puts the
+// one of a received request in the context of the request's Get, so
+// that Tracers can continue the caller's trace.
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// injectTrace sets the trace headers of the TraceContext of ctx on h.
+func injectTrace(ctx context.Context, h http.Header) {
+	tc, ok := TraceFromContext(ctx)
+	if !ok || !tc.IsValid() {
+		return
+	}
+	h.Set("traceparent", tc.Traceparent())
+	if tc.State != "" {
+		h.Set("tracestate", tc.State)
+	}
+}
+
+// extractTrace returns ctx carrying the TraceContext of h, if it has a
+// valid one.
+func extractTrace(ctx context.Context, h http.Header) context.Context {
+	tc, err := ParseTraceparent(h.Get("traceparent"))
+	if err != nil {
+		return ctx
+	}
+	tc.State = strings.Join(h.Values("tracestate"), ",")
+	return ContextWithTrace(ctx, tc)
+}
+
+// A Tracer starts the spans of a Group. See GroupOptions.Tracer.
+//
+// It is meant to be implemented by adapters to tracing libraries,
+// such as OpenTelemetry.
+type Tracer interface {
+	// Start starts a span named name, as a child of the span of
+	// ctx, if any. The parent may be a remote span known only by
+	// the TraceContext of ctx. The returned context carries the
+	// new span and is passed to the Getter and to peers.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span is an operation traced by a Tracer.
+type Span interface {
+	// TraceContext returns the identity of the span, propagated to
+	// the peers the span's requests are sent to.
+	TraceContext() TraceContext
+
+	// SetAttribute annotates the span.
+	SetAttribute(key, value string)
+
+	// End ends the span, which failed if err is non-nil.
+	End(err error)
+}
+
+// The names of the spans started by a Group.
+const (
+	SpanLookup    = "groupcache.lookup"     // a Get or GetMulti, from cache lookup to load
+	SpanPeerFetch = "groupcache.peer_fetch" // a request to a peer
+	SpanLocalLoad = "groupcache.local_load" // a call of the Getter
+)
+
+// startSpan starts a span of g's tracer annotated with the group and
+// key, returning a context carrying its TraceContext.
+func (g *Group) startSpan(ctx context.Context, name, key string) (context.Context, Span) {
+	if g.opts.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	ctx, span := g.opts.Tracer.Start(ctx, name)
+	span.SetAttribute("groupcache.group", g.name)
+	if key != "" {
+		span.SetAttribute("groupcache.key", key)
+	}
+	if tc := span.TraceContext(); tc.IsValid() {
+		ctx = ContextWithTrace(ctx, tc)
+	}
+	return ctx, span
+}
+
+type noopSpan struct{}
+
+func (noopSpan) TraceContext() TraceContext     { return TraceContext{} }
+func (noopSpan) SetAttribute(key, value string) {}
+func (noopSpan) End(err error)                  {}
@@ -0,0 +1,206 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	pb "github.com/golang/groupcache/groupcachepb"
+	"github.com/golang/protobuf/proto"
+)
+
+// recordingTracer is a Tracer keeping its spans in memory.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	t      *recordingTracer
+	name   string
+	parent TraceContext // zero for root spans
+	tc     TraceContext
+	attrs  map[string]string
+	err    error
+	ended  bool
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &recordedSpan{t: t, name: name, attrs: make(map[string]string)}
+	if parent, ok := TraceFromContext(ctx); ok {
+		s.parent = parent
+		s.tc = parent
+	} else {
+		binary.BigEndian.PutUint64(s.tc.TraceID[8:], uint64(len(t.spans)+1))
+		s.tc.Flags = 1
+	}
+	binary.BigEndian.PutUint64(s.tc.SpanID[:], uint64(len(t.spans)+1))
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+func (s *recordedSpan) TraceContext() TraceContext { return s.tc }
+
+func (s *recordedSpan) SetAttribute(key, value string) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) End(err error) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.err = err
+	s.ended = true
+}
+
+// take returns and forgets the recorded spans.
+func (t *recordingTracer) take() []*recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	spans := t.spans
+	t.spans = nil
+	return spans
+}
+
+func TestTraceparent(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tc, err := ParseTraceparent(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.TraceID[0] != 0x4b || tc.SpanID[7] != 0xb7 || tc.Flags != 1 {
+		t.Errorf("ParseTraceparent(%q) = %+v", header, tc)
+	}
+	if got := tc.Traceparent(); got != header {
+		t.Errorf("Traceparent() = %q; want %q", got, header)
+	}
+
+	// Later versions may append fields.
+	if _, err := ParseTraceparent("01" + header[2:] + "-future"); err != nil {
+		t.Errorf("later version: %v", err)
+	}
+	for _, bad := range []string{
+		"",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01",
+		"ff" + header[2:],
+		header + "-extra",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(bad); err == nil {
+			t.Errorf("ParseTraceparent(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestGroupTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	var getterTrace TraceContext
+	g := DefaultRegistry.newGroup("TestGroupTracing-group", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		getterTrace, _ = TraceFromContext(ctx)
+		return dest.SetString("got:" + key)
+	}), fakePeers{&fakePeer{fail: true}}, &GroupOptions{Tracer: tracer})
+
+	remote, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ContextWithTrace(context.Background(), remote)
+	var s string
+	if err := g.Get(ctx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	spans := tracer.take()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans; want 3", len(spans))
+	}
+	lookup, fetch, local := spans[0], spans[1], spans[2]
+	for _, c := range []struct {
+		span   *recordedSpan
+		name   string
+		parent TraceContext
+		failed bool
+	}{
+		{lookup, SpanLookup, remote, false},
+		{fetch, SpanPeerFetch, lookup.tc, true},
+		{local, SpanLocalLoad, lookup.tc, false},
+	} {
+		if c.span.name != c.name {
+			t.Errorf("span name = %q; want %q", c.span.name, c.name)
+		}
+		if c.span.parent != c.parent {
+			t.Errorf("%s: parent = %+v; want %+v", c.name, c.span.parent, c.parent)
+		}
+		if !c.span.ended || (c.span.err != nil) != c.failed {
+			t.Errorf("%s: ended = %v, err = %v", c.name, c.span.ended, c.span.err)
+		}
+		if c.span.attrs["groupcache.group"] != "TestGroupTracing-group" || c.span.attrs["groupcache.key"] != "key" {
+			t.Errorf("%s: attributes = %v", c.name, c.span.attrs)
+		}
+	}
+	if fetch.attrs["groupcache.peer"] != "*groupcache.fakePeer" {
+		t.Errorf("peer fetch attributes = %v", fetch.attrs)
+	}
+	if getterTrace != local.tc {
+		t.Errorf("getter's trace = %+v; want the local load span's %+v", getterTrace, local.tc)
+	}
+
+	// A cache hit is a single lookup span naming the cache.
+	if err := g.Get(context.Background(), "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	spans = tracer.take()
+	if len(spans) != 1 || spans[0].name != SpanLookup || spans[0].attrs["groupcache.cache"] != "main" {
+		t.Errorf("cache hit spans = %+v; want one lookup of the main cache", spans)
+	}
+}
+
+func TestHTTPPoolTracePropagation(t *testing.T) {
+	tracer := &recordingTracer{}
+	r := NewRegistry()
+	r.newGroup("httpPoolTraceTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), NoPeers{}, &GroupOptions{Tracer: tracer})
+	_, srv := startTestPool(r)
+	defer srv.Close()
+
+	tc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.State = "vendor=opaque"
+	h := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	req := &pb.GetRequest{Group: proto.String("httpPoolTraceTest"), Key: proto.String("k")}
+	if err := h.Get(ContextWithTrace(context.Background(), tc), req, &pb.GetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	spans := tracer.take()
+	if len(spans) == 0 || spans[0].name != SpanLookup {
+		t.Fatalf("server spans = %+v; want a lookup first", spans)
+	}
+	if spans[0].parent != tc {
+		t.Errorf("server lookup parent = %+v; want the client's %+v", spans[0].parent, tc)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// A Codec converts values of type T to the bytes a Group caches and
+// back. Decode may keep data.
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// A TypedGetterFunc loads the value of type T of a key, and when it
+// expires. The zero expire means the value never expires; see
+// Sink.SetExpire.
+type TypedGetterFunc[T any] func(ctx context.Context, key string) (value T, expire time.Time, err error)
+
+// A TypedGroup is a Group whose values are of type T. Values are
+// encoded with a Codec and cached as bytes, so peers need not know
+// T, and may run plain Groups of the same name.
+type TypedGroup[T any] struct {
+	group *Group
+	codec Codec[T]
+}
+
+// NewTypedGroup creates a TypedGroup in DefaultRegistry. Like
+// NewGroup, it creates a Group of the given name, whose values are
+// loaded by getter and encoded with codec.
+func NewTypedGroup[T any](name string, cacheBytes int64, codec Codec[T], getter TypedGetterFunc[T]) *TypedGroup[T] {
+	return NewTypedGroupOpts(DefaultRegistry, name, cacheBytes, codec, getter, nil)
+}
+
+// NewTypedGroupOpts is like NewTypedGroup, but creates the group in
+// r, with the given options. A nil o is equivalent to an empty
+// GroupOptions.
+func NewTypedGroupOpts[T any](r *Registry, name string, cacheBytes int64, codec Codec[T], getter TypedGetterFunc[T], o *GroupOptions) *TypedGroup[T] {
+	if getter == nil {
+		panic("nil TypedGetterFunc")
+	}
+	g := r.NewGroupOpts(name, cacheBytes, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		v, expire, err := getter(ctx, key)
+		if err != nil {
+			return err
+		}
+		b, err := codec.Encode(v)
+		if err != nil {
+			return err
+		}
+		dest.SetExpire(expire)
+		return dest.SetBytes(b)
+	}), o)
+	return &TypedGroup[T]{group: g, codec: codec}
+}
+
+// Group returns the underlying Group, for its other methods such as
+// Remove and its Stats.
+func (tg *TypedGroup[T]) Group() *Group {
+	return tg.group
+}
+
+// Get returns the value of key, decoded.
+func (tg *TypedGroup[T]) Get(ctx context.Context, key string) (T, error) {
+	var v ByteView
+	if err := tg.group.Get(ctx, key, ByteViewSink(&v)); err != nil {
+		var zero T
+		return zero, err
+	}
+	return tg.codec.Decode(v.ByteSlice())
+}
+
+// Set encodes value and stores it as key's value. See Group.Set.
+func (tg *TypedGroup[T]) Set(ctx context.Context, key string, value T, expire time.Time, hotCache bool) error {
+	b, err := tg.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	return tg.group.Set(ctx, key, b, expire, hotCache)
+}
+
+// BytesCodec is a Codec of raw bytes, stored as is.
+type BytesCodec struct{}
+
+func (BytesCodec) Encode(v []byte) ([]byte, error)    { return v, nil }
+func (BytesCodec) Decode(data []byte) ([]byte, error) { return data, nil }
+
+// JSONCodec is a Codec that encodes values as JSON.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// GobCodec is a Codec that encodes values with encoding/gob. Each
+// value is encoded on its own, along with its type description.
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// ProtoCodec is a Codec of protocol buffer messages of type *T.
+// Create one with NewProtoCodec.
+type ProtoCodec[T any, P interface {
+	*T
+	proto.Message
+}] struct{}
+
+// NewProtoCodec returns a Codec of messages of type *T, as in
+// NewProtoCodec[pb.MyMessage]().
+func NewProtoCodec[T any, P interface {
+	*T
+	proto.Message
+}]() ProtoCodec[T, P] {
+	return ProtoCodec[T, P]{}
+}
+
+func (ProtoCodec[T, P]) Encode(v P) ([]byte, error) {
+	return proto.Marshal(v)
+}
+
+func (ProtoCodec[T, P]) Decode(data []byte) (P, error) {
+	m := P(new(T))
+	if err := proto.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	testpb "github.com/golang/groupcache/testpb"
+	"github.com/golang/protobuf/proto"
+)
+
+type typedTestValue struct {
+	Name  string
+	Count int
+}
+
+func TestTypedGroupCodecs(t *testing.T) {
+	r := NewRegistry()
+	loads := 0
+	load := func(_ context.Context, key string) (typedTestValue, time.Time, error) {
+		loads++
+		if key == "missing" {
+			return typedTestValue{}, time.Time{}, errors.New("no such key")
+		}
+		return typedTestValue{Name: key, Count: len(key)}, time.Time{}, nil
+	}
+	for _, codec := range []Codec[typedTestValue]{JSONCodec[typedTestValue]{}, GobCodec[typedTestValue]{}} {
+		loads = 0
+		tg := NewTypedGroupOpts(r, fmt.Sprintf("typed-%T", codec), cacheSize, codec, load, nil)
+		for i := 0; i < 2; i++ {
+			v, err := tg.Get(dummyCtx, "abc")
+			if err != nil {
+				t.Fatalf("%T: Get: %v", codec, err)
+			}
+			if want := (typedTestValue{Name: "abc", Count: 3}); v != want {
+				t.Errorf("%T: Get = %+v; want %+v", codec, v, want)
+			}
+		}
+		if loads != 1 {
+			t.Errorf("%T: %d loads; want 1", codec, loads)
+		}
+		if _, err := tg.Get(dummyCtx, "missing"); err == nil {
+			t.Errorf("%T: Get of missing key succeeded", codec)
+		}
+
+		if err := tg.Set(dummyCtx, "pushed", typedTestValue{Name: "p"}, time.Time{}, false); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := tg.Get(dummyCtx, "pushed"); err != nil || v.Name != "p" {
+			t.Errorf("%T: Get after Set = %+v, %v", codec, v, err)
+		}
+	}
+}
+
+func TestTypedGroupBytesAndProto(t *testing.T) {
+	r := NewRegistry()
+	bg := NewTypedGroupOpts(r, "typed-bytes", cacheSize, BytesCodec{}, func(_ context.Context, key string) ([]byte, time.Time, error) {
+		return []byte("raw:" + key), time.Time{}, nil
+	}, nil)
+	b, err := bg.Get(dummyCtx, "k")
+	if err != nil || string(b) != "raw:k" {
+		t.Errorf("bytes Get = %q, %v; want raw:k", b, err)
+	}
+	b[0] = 'X' // callers own the result
+	if b, _ := bg.Get(dummyCtx, "k"); string(b) != "raw:k" {
+		t.Errorf("cached value changed to %q", b)
+	}
+
+	pg := NewTypedGroupOpts(r, "typed-proto", cacheSize, NewProtoCodec[testpb.TestMessage](), func(_ context.Context, key string) (*testpb.TestMessage, time.Time, error) {
+		return &testpb.TestMessage{Name: proto.String("name:" + key), City: proto.String("SF")}, time.Time{}, nil
+	}, nil)
+	m, err := pg.Get(dummyCtx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.GetName() != "name:k" || m.GetCity() != "SF" {
+		t.Errorf("proto Get = %v", m)
+	}
+
+	// The values are plain bytes to the underlying Group.
+	var raw []byte
+	if err := pg.Group().Get(dummyCtx, "k", AllocatingByteSliceSink(&raw)); err != nil {
+		t.Fatal(err)
+	}
+	var m2 testpb.TestMessage
+	if err := proto.Unmarshal(raw, &m2); err != nil || m2.GetName() != "name:k" {
+		t.Errorf("raw value decodes to %v, %v", &m2, err)
+	}
+}
+
+func TestTypedGroupExpire(t *testing.T) {
+	clock, restore := useFakeClock(time.Unix(1000, 0))
+	defer restore()
+	loads := 0
+	tg := NewTypedGroupOpts(NewRegistry(), "typed-expire", cacheSize, JSONCodec[int]{}, func(_ context.Context, key string) (int, time.Time, error) {
+		loads++
+		return loads, nowFunc().Add(time.Minute), nil
+	}, nil)
+	for _, want := range []int{1, 1} {
+		if v, err := tg.Get(dummyCtx, "k"); err != nil || v != want {
+			t.Fatalf("Get = %d, %v; want %d", v, err, want)
+		}
+	}
+	clock.Advance(time.Minute)
+	if v, err := tg.Get(dummyCtx, "k"); err != nil || v != 2 {
+		t.Errorf("Get after expiry = %d, %v; want 2", v, err)
+	}
+}